@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"project/internal/importer"
+	"project/internal/store"
+)
+
+// runImport выполняет подкоманду import: загружает brands.csv и cars.csv в хранилище
+func runImport(store store.Store, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	brandsPath := fs.String("brands", "brands.csv", "path to brands csv, empty to skip")
+	carsPath := fs.String("cars", "cars.csv", "path to cars csv, empty to skip")
+	userID := fs.Int("user-id", 0, "owner of the imported cars")
+	dryRun := fs.Bool("dry-run", false, "validate rows without writing them")
+	upsert := fs.Bool("upsert", false, "keep ids from the files and overwrite existing rows")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *carsPath != "" && *userID == 0 && !*dryRun {
+		return fmt.Errorf("-user-id is required to import cars")
+	}
+
+	ctx := context.Background()
+	im := importer.New(store, importer.Options{DryRun: *dryRun, Upsert: *upsert, UserID: *userID})
+
+	failed := 0
+	if *brandsPath != "" {
+		n, err := importFile(ctx, *brandsPath, im.Brands)
+		if err != nil {
+			return err
+		}
+		failed += n
+	}
+	if *carsPath != "" {
+		n, err := importFile(ctx, *carsPath, im.Cars)
+		if err != nil {
+			return err
+		}
+		failed += n
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d rows failed", failed)
+	}
+	return nil
+}
+
+// importFile печатает отчет по файлу и возвращает количество строк с ошибками
+func importFile(ctx context.Context, path string, run func(ctx context.Context, r io.Reader) (*importer.Report, error)) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	report, err := run(ctx, f)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, rowErr := range report.Failed {
+		fmt.Printf("%s: %v\n", path, rowErr)
+	}
+	fmt.Printf("%s: imported %d, failed %d\n", path, report.Imported, len(report.Failed))
+	return len(report.Failed), nil
+}
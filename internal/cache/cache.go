@@ -0,0 +1,228 @@
+// Package cache кэш, разделенный на пространства имен. У каждого пространства свой тип
+// значений и своя емкость, поэтому ключи разных ресурсов не пересекаются
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// loadTimeout сколько ждать загрузки значения, в том числе фонового обновления устаревшей записи
+const loadTimeout = 10 * time.Second
+
+// Backend хранилище кэша, например LRU в памяти процесса
+type Backend interface {
+	// Bucket возвращает часть кэша для пространства имен name. Повторный вызов с тем же именем
+	// возвращает ту же часть, capacity учитывается при первом вызове
+	Bucket(name string, capacity int) Bucket
+	// Invalidate удаляет записи всех пространств имен, помеченные хотя бы одним из тегов
+	Invalidate(tags ...string)
+	// Generation меняется при каждой инвалидации любого из тегов. Снимается до загрузки значения,
+	// чтобы Bucket.Add не сохранил то, что устарело, пока оно загружалось. Если поколение
+	// не прочитать, значение не кэшируется
+	Generation(tags ...string) (uint64, error)
+}
+
+// Bucket часть кэша одного пространства имен
+type Bucket interface {
+	// Get возвращает сохраненное значение как есть, а хранилища вне процесса - его JSON ([]byte)
+	Get(key string) (interface{}, bool)
+	// Add сохраняет значение с тегами того, от чего оно зависит, например car:3 или city:Almaty.
+	// keep - сколько запись нужна, 0 - без срока. Хранилище может удалить ее после этого.
+	// gen - Generation(tags...) до загрузки значения: если теги с тех пор инвалидировали, значение
+	// не сохраняется
+	Add(key string, value interface{}, tags []string, keep time.Duration, gen uint64)
+	Remove(key string)
+	Purge()
+}
+
+// Namespace типизированное пространство имен поверх Backend
+type Namespace[V any] struct {
+	name    string
+	backend Backend
+	bucket  Bucket
+	options options
+	flights flightGroup
+}
+
+type options struct {
+	ttl      time.Duration
+	jitter   float64
+	staleFor time.Duration
+}
+
+type Option func(o *options)
+
+// WithTTL задает срок жизни записей. Срок каждой записи случайно отклоняется на долю jitter,
+// чтобы записи, добавленные одновременно, не истекали тоже одновременно
+func WithTTL(ttl time.Duration, jitter float64) Option {
+	return func(o *options) {
+		o.ttl = ttl
+		o.jitter = jitter
+	}
+}
+
+// WithStaleWhileRevalidate еще staleFor после истечения TTL GetOrLoad отдает старое значение
+// и обновляет его в фоне
+func WithStaleWhileRevalidate(staleFor time.Duration) Option {
+	return func(o *options) {
+		o.staleFor = staleFor
+	}
+}
+
+// entry значение со сроками. Нулевой ExpiresAt - без срока
+type entry[V any] struct {
+	Value      V         `json:"value"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	StaleUntil time.Time `json:"stale_until"`
+}
+
+func (e *entry[V]) fresh(now time.Time) bool {
+	return e.ExpiresAt.IsZero() || now.Before(e.ExpiresAt)
+}
+
+func (e *entry[V]) usable(now time.Time) bool {
+	return e.fresh(now) || now.Before(e.StaleUntil)
+}
+
+func NewNamespace[V any](backend Backend, name string, capacity int, opts ...Option) *Namespace[V] {
+	n := &Namespace[V]{
+		name:    name,
+		backend: backend,
+		bucket:  backend.Bucket(name, capacity),
+	}
+	for _, opt := range opts {
+		opt(&n.options)
+	}
+	return n
+}
+
+func (n *Namespace[V]) Name() string {
+	return n.name
+}
+
+// Get возвращает только неистекшее значение
+func (n *Namespace[V]) Get(key string) (V, bool) {
+	e, ok := n.entry(key)
+	if !ok || !e.fresh(time.Now()) {
+		var zero V
+		return zero, false
+	}
+	return e.Value, true
+}
+
+// Generation снимается до загрузки значения из базы и передается в Add
+func (n *Namespace[V]) Generation(tags ...string) (uint64, error) {
+	return n.backend.Generation(tags...)
+}
+
+// Add сохраняет значение, загруженное после Generation(tags...) = gen. Если запись в базу
+// успела сбросить его теги, значение устарело и не сохраняется
+func (n *Namespace[V]) Add(key string, value V, gen uint64, tags ...string) {
+	e := &entry[V]{Value: value}
+	var keep time.Duration
+	if n.options.ttl > 0 {
+		ttl := n.options.ttl
+		if n.options.jitter > 0 {
+			ttl += time.Duration((rand.Float64()*2 - 1) * n.options.jitter * float64(ttl))
+		}
+		now := time.Now()
+		e.ExpiresAt = now.Add(ttl)
+		e.StaleUntil = e.ExpiresAt.Add(n.options.staleFor)
+		keep = ttl + n.options.staleFor
+	}
+	n.bucket.Add(key, e, tags, keep, gen)
+}
+
+// GetOrLoad отдает значение из кэша, а при промахе загружает его через load. Одновременные
+// промахи по одному ключу делают один вызов load, остальные получают его результат.
+// Каждый вызов перестает ждать при отмене своего ctx, сама загрузка при этом продолжается
+func (n *Namespace[V]) GetOrLoad(ctx context.Context, key string, tags []string, load func(ctx context.Context) (V, error)) (V, error) {
+	now := time.Now()
+	if e, ok := n.entry(key); ok && e.usable(now) {
+		if !e.fresh(now) {
+			n.refresh(key, tags, load)
+		}
+		return e.Value, nil
+	}
+
+	f := n.startLoad(key, tags, load)
+	select {
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	case <-f.done:
+	}
+	if f.err != nil {
+		var zero V
+		return zero, f.err
+	}
+	return f.value.(V), nil
+}
+
+// refresh обновляет устаревшую запись в фоне, не больше одного обновления на ключ
+func (n *Namespace[V]) refresh(key string, tags []string, load func(ctx context.Context) (V, error)) {
+	if n.flights.running(key) {
+		return
+	}
+	f := n.startLoad(key, tags, load)
+	go func() {
+		<-f.done
+		if f.err != nil {
+			log.Printf("[cache] refresh %s/%s: %v", n.name, key, f.err)
+		}
+	}()
+}
+
+// startLoad запускает общую для всех ожидающих загрузку. Ее контекст не связан с запросом, который
+// ее начал, иначе отключение одного клиента вернуло бы ошибку всем остальным
+func (n *Namespace[V]) startLoad(key string, tags []string, load func(ctx context.Context) (V, error)) *flight {
+	return n.flights.start(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+		defer cancel()
+		gen, genErr := n.backend.Generation(tags...)
+		value, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// без поколения не проверить, не устарело ли значение за время загрузки
+		if genErr != nil {
+			log.Printf("[cache] generation %s/%s: %v", n.name, key, genErr)
+			return value, nil
+		}
+		n.Add(key, value, gen, tags...)
+		return value, nil
+	})
+}
+
+func (n *Namespace[V]) entry(key string) (*entry[V], bool) {
+	value, ok := n.bucket.Get(key)
+	if !ok {
+		return nil, false
+	}
+	switch value := value.(type) {
+	case *entry[V]:
+		return value, true
+	case []byte:
+		e := new(entry[V])
+		if err := json.Unmarshal(value, e); err != nil {
+			log.Printf("[cache] decode %s/%s: %v", n.name, key, err)
+			return nil, false
+		}
+		return e, true
+	default:
+		return nil, false
+	}
+}
+
+func (n *Namespace[V]) Remove(key string) {
+	n.bucket.Remove(key)
+}
+
+// Purge очищает только это пространство имен
+func (n *Namespace[V]) Purge() {
+	n.bucket.Purge()
+}
@@ -0,0 +1,330 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"project/internal/cache/redistest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backends LRU и Redis поверх сервера-заглушки: поведение Namespace не должно от них зависеть
+func backends(t *testing.T) map[string]Backend {
+	t.Helper()
+	_, redis := newTestRedis(t)
+	return map[string]Backend{"lru": NewLRU(), "redis": redis}
+}
+
+func newTestRedis(t *testing.T) (*redistest.Server, *Redis) {
+	t.Helper()
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	redis, err := NewRedis(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(redis.Close)
+	return server, redis
+}
+
+// add сохраняет значение так, будто оно только что загружено
+func add[V any](t *testing.T, n *Namespace[V], key string, value V, tags ...string) {
+	t.Helper()
+	gen, err := n.Generation(tags...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Add(key, value, gen, tags...)
+}
+
+func TestInvalidateRemovesTaggedEntries(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			cars := NewNamespace[string](backend, "cars", 0)
+			car := NewNamespace[string](backend, "car", 0)
+			add(t, cars, "almaty", "niva", "cars:list", "city:almaty")
+			add(t, cars, "astana", "vesta", "cars:list", "city:astana")
+			add(t, car, "1", "niva", "car:1")
+
+			backend.Invalidate("city:almaty")
+			if _, ok := cars.Get("almaty"); ok {
+				t.Error("entry tagged city:almaty survived its invalidation")
+			}
+			if value, ok := cars.Get("astana"); !ok || value != "vesta" {
+				t.Errorf("entry of another city = %q, %v, want it cached", value, ok)
+			}
+
+			backend.Invalidate("cars:list")
+			if _, ok := cars.Get("astana"); ok {
+				t.Error("entry tagged cars:list survived its invalidation")
+			}
+			if value, ok := car.Get("1"); !ok || value != "niva" {
+				t.Errorf("entry of another namespace = %q, %v, want it cached", value, ok)
+			}
+		})
+	}
+}
+
+// TestAddSkipsValueInvalidatedDuringLoad значение прочитано до записи в базу, а сохраняется
+// после ее инвалидации: оно устарело и не должно попасть в кэш
+func TestAddSkipsValueInvalidatedDuringLoad(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			lists := NewNamespace[string](backend, "cars", 0)
+
+			gen, err := lists.Generation("cars:list")
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend.Invalidate("cars:list")
+			lists.Add("page", "before write", gen, "cars:list")
+			if value, ok := lists.Get("page"); ok {
+				t.Fatalf("stale value %q cached after the invalidation", value)
+			}
+
+			add(t, lists, "page", "after write", "cars:list")
+			if value, ok := lists.Get("page"); !ok || value != "after write" {
+				t.Errorf("fresh value not cached: %q, %v", value, ok)
+			}
+		})
+	}
+}
+
+func TestAddAfterInvalidateOfOtherTag(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := backend.(*LRU); ok && genStripe("brands:list") == genStripe("city:almaty") {
+				t.Skip("tags share a generation stripe")
+			}
+			lists := NewNamespace[string](backend, "cars", 0)
+
+			gen, err := lists.Generation("city:almaty")
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend.Invalidate("brands:list")
+			lists.Add("almaty", "niva", gen, "city:almaty")
+			if _, ok := lists.Get("almaty"); !ok {
+				t.Error("value dropped after the invalidation of an unrelated tag")
+			}
+		})
+	}
+}
+
+func TestGetOrLoadCoalescesMisses(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			cars := NewNamespace[string](backend, "car", 0, WithTTL(time.Minute, 0))
+			var loads int32
+			release := make(chan struct{})
+			load := func(ctx context.Context) (string, error) {
+				atomic.AddInt32(&loads, 1)
+				<-release
+				return "niva", nil
+			}
+
+			results := make(chan string)
+			for i := 0; i < 5; i++ {
+				go func() {
+					value, err := cars.GetOrLoad(context.Background(), "1", []string{"car:1"}, load)
+					if err != nil {
+						t.Error(err)
+					}
+					results <- value
+				}()
+			}
+			for !cars.flights.running("1") {
+				time.Sleep(time.Millisecond)
+			}
+			close(release)
+			for i := 0; i < 5; i++ {
+				if value := <-results; value != "niva" {
+					t.Errorf("GetOrLoad = %q, want niva", value)
+				}
+			}
+			if n := atomic.LoadInt32(&loads); n != 1 {
+				t.Errorf("load called %d times for one key, want 1", n)
+			}
+		})
+	}
+}
+
+// TestGetOrLoadServesStaleWhileRefreshing истекшее значение отдается, пока фоновое обновление
+// не сохранит новое
+func TestGetOrLoadServesStaleWhileRefreshing(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			lists := NewNamespace[string](backend, "cars", 0, WithTTL(10*time.Millisecond, 0), WithStaleWhileRevalidate(time.Minute))
+			tags := []string{"cars:list"}
+			if _, err := lists.GetOrLoad(context.Background(), "page", tags, func(ctx context.Context) (string, error) {
+				return "old", nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			value, err := lists.GetOrLoad(context.Background(), "page", tags, func(ctx context.Context) (string, error) {
+				return "new", nil
+			})
+			if err != nil || value != "old" {
+				t.Fatalf("GetOrLoad after expiry = %q, %v, want the stale value", value, err)
+			}
+			for lists.flights.running("page") {
+				time.Sleep(time.Millisecond)
+			}
+			if value, ok := lists.Get("page"); !ok || value != "new" {
+				t.Errorf("refreshed value = %q, %v, want new", value, ok)
+			}
+		})
+	}
+}
+
+// TestGetOrLoadDropsValueInvalidatedDuringLoad загрузка началась до записи в базу, а закончилась
+// после ее инвалидации: старое значение отдается, но не кэшируется
+func TestGetOrLoadDropsValueInvalidatedDuringLoad(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			lists := NewNamespace[string](backend, "cars", 0, WithTTL(time.Minute, 0))
+			tags := []string{"cars:list"}
+
+			started, release := make(chan struct{}), make(chan struct{})
+			done := make(chan string)
+			go func() {
+				value, err := lists.GetOrLoad(context.Background(), "page", tags, func(ctx context.Context) (string, error) {
+					close(started)
+					<-release
+					return "before write", nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				done <- value
+			}()
+
+			<-started
+			backend.Invalidate("cars:list")
+			close(release)
+			if value := <-done; value != "before write" {
+				t.Fatalf("GetOrLoad = %q, want the loaded value", value)
+			}
+			if value, ok := lists.Get("page"); ok {
+				t.Fatalf("stale value %q cached after the mutation", value)
+			}
+		})
+	}
+}
+
+// unknownGenerations хранилище, которое не может прочитать поколения
+type unknownGenerations struct {
+	Backend
+}
+
+func (unknownGenerations) Generation(tags ...string) (uint64, error) {
+	return 0, errors.New("generation unavailable")
+}
+
+func TestGetOrLoadSkipsCachingWithoutGeneration(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			cars := NewNamespace[string](unknownGenerations{backend}, "car", 0, WithTTL(time.Minute, 0))
+			value, err := cars.GetOrLoad(context.Background(), "1", []string{"car:1"}, func(ctx context.Context) (string, error) {
+				return "niva", nil
+			})
+			if err != nil || value != "niva" {
+				t.Fatalf("GetOrLoad = %q, %v, want the loaded value", value, err)
+			}
+			if _, ok := cars.Get("1"); ok {
+				t.Error("value cached although its generation was unknown")
+			}
+		})
+	}
+}
+
+// TestGetOrLoadWaiterOutlivesCanceledLoader отмена запроса, начавшего загрузку, не должна
+// отменять ее для остальных ожидающих
+func TestGetOrLoadWaiterOutlivesCanceledLoader(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			cars := NewNamespace[string](backend, "car", 0)
+			release := make(chan struct{})
+			load := func(ctx context.Context) (string, error) {
+				select {
+				case <-release:
+					return "niva", nil
+				case <-ctx.Done():
+					return "", ctx.Err()
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			first := make(chan error)
+			go func() {
+				_, err := cars.GetOrLoad(ctx, "1", nil, load)
+				first <- err
+			}()
+			for !cars.flights.running("1") {
+				time.Sleep(time.Millisecond)
+			}
+			second := make(chan string)
+			go func() {
+				value, err := cars.GetOrLoad(context.Background(), "1", nil, load)
+				if err != nil {
+					t.Error(err)
+				}
+				second <- value
+			}()
+
+			cancel()
+			if err := <-first; err != context.Canceled {
+				t.Fatalf("canceled caller got %v, want context.Canceled", err)
+			}
+			close(release)
+			if value := <-second; value != "niva" {
+				t.Errorf("waiter got %q, want the loaded value", value)
+			}
+			if value, ok := cars.Get("1"); !ok || value != "niva" {
+				t.Errorf("loaded value not cached: %q, %v", value, ok)
+			}
+		})
+	}
+}
+
+func TestRedisGenerationFailsWhileUnavailable(t *testing.T) {
+	server, redis := newTestRedis(t)
+	server.FailWith("LOADING Redis is loading the dataset in memory")
+	if _, err := redis.Generation("car:1"); err == nil {
+		t.Error("Generation succeeded while Redis failed every command")
+	}
+}
+
+// TestRedisInvalidateRetriedAfterFailure инвалидация, которую Redis не принял, повторяется,
+// когда он снова отвечает, и запись не доживает до истечения срока
+func TestRedisInvalidateRetriedAfterFailure(t *testing.T) {
+	server, redis := newTestRedis(t)
+	cars := NewNamespace[string](redis, "car", 0, WithTTL(time.Hour, 0))
+	add(t, cars, "1", "niva", "car:1")
+
+	server.FailWith("LOADING Redis is loading the dataset in memory")
+	redis.Invalidate("car:1")
+	server.FailWith("")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := cars.Get("1"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry survived a failed invalidation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// поколение тоже увеличено: значение, загруженное до записи, не сохранится
+	gen, err := cars.Generation("car:1")
+	if err != nil || gen == 0 {
+		t.Errorf("generation after the retried invalidation = %d, %v", gen, err)
+	}
+}
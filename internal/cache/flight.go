@@ -0,0 +1,48 @@
+package cache
+
+import "sync"
+
+// flightGroup объединяет одновременные промахи по одному ключу в один запрос к базе,
+// остальные ждут его результат
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// start запускает fn в отдельной горутине, если по ключу еще нет запроса, иначе возвращает уже начатый.
+// Результат доступен после закрытия done
+func (g *flightGroup) start(key string, fn func() (interface{}, error)) *flight {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	if f, ok := g.flights[key]; ok {
+		return f
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+
+	go func() {
+		f.value, f.err = fn()
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// running true, если по ключу уже идет запрос
+func (g *flightGroup) running(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.flights[key]
+	return ok
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	lru "github.com/hashicorp/golang-lru"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCapacity емкость пространства имен, для которого она не задана
+	DefaultCapacity = 128
+	// genStripes число счетчиков поколений. Теги делят их по хэшу: память не растет с числом тегов,
+	// а совпадение хэшей лишь изредка мешает сохранить значение
+	genStripes = 256
+)
+
+// LRU кэш в памяти процесса: у каждого пространства имен свой 2Q LRU, теги общие
+type LRU struct {
+	mu      sync.Mutex
+	buckets map[string]*lruBucket
+	// tags записи, помеченные тегом. Вытесненные LRU записи удаляются отсюда при prune
+	tags map[string]map[entryRef]struct{}
+	// gens счетчики инвалидаций тегов по genStripes полосам
+	gens [genStripes]uint64
+}
+
+type entryRef struct {
+	bucket string
+	key    string
+}
+
+func NewLRU() *LRU {
+	return &LRU{
+		buckets: make(map[string]*lruBucket),
+		tags:    make(map[string]map[entryRef]struct{}),
+	}
+}
+
+func (l *LRU) Bucket(name string, capacity int) Bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bucket, ok := l.buckets[name]; ok {
+		return bucket
+	}
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	cache, err := lru.New2Q(capacity)
+	if err != nil {
+		// New2Q ошибается только на неположительной емкости, она отсечена выше
+		panic(err)
+	}
+	bucket := &lruBucket{
+		owner:    l,
+		name:     name,
+		capacity: capacity,
+		cache:    cache,
+		keyTags:  make(map[string][]string),
+	}
+	l.buckets[name] = bucket
+	return bucket
+}
+
+func (l *LRU) Invalidate(tags ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, tag := range tags {
+		l.gens[genStripe(tag)]++
+		for ref := range l.tags[tag] {
+			if bucket, ok := l.buckets[ref.bucket]; ok {
+				bucket.cache.Remove(ref.key)
+				bucket.untag(ref.key)
+			}
+		}
+		delete(l.tags, tag)
+	}
+}
+
+func (l *LRU) Generation(tags ...string) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.generation(tags), nil
+}
+
+// generation вызывается под l.mu
+func (l *LRU) generation(tags []string) uint64 {
+	var gen uint64
+	for _, tag := range tags {
+		gen += l.gens[genStripe(tag)]
+	}
+	return gen
+}
+
+func genStripe(tag string) int {
+	h := fnv.New32a()
+	h.Write([]byte(tag))
+	return int(h.Sum32() % genStripes)
+}
+
+type lruBucket struct {
+	owner    *LRU
+	name     string
+	capacity int
+	cache    *lru.TwoQueueCache
+	// keyTags теги каждого ключа, чтобы при перезаписи и удалении снять старые
+	keyTags map[string][]string
+}
+
+func (b *lruBucket) Get(key string) (interface{}, bool) {
+	return b.cache.Get(key)
+}
+
+// Add keep не используется: истекшие записи вытесняет LRU, а Namespace их не отдает
+func (b *lruBucket) Add(key string, value interface{}, tags []string, keep time.Duration, gen uint64) {
+	b.owner.mu.Lock()
+	defer b.owner.mu.Unlock()
+
+	if b.owner.generation(tags) != gen {
+		return
+	}
+	b.untag(key)
+	b.cache.Add(key, value)
+	if len(tags) == 0 {
+		return
+	}
+	b.keyTags[key] = tags
+	for _, tag := range tags {
+		refs, ok := b.owner.tags[tag]
+		if !ok {
+			refs = make(map[entryRef]struct{})
+			b.owner.tags[tag] = refs
+		}
+		refs[entryRef{bucket: b.name, key: key}] = struct{}{}
+	}
+	if len(b.keyTags) > 2*b.capacity {
+		b.prune()
+	}
+}
+
+func (b *lruBucket) Remove(key string) {
+	b.owner.mu.Lock()
+	defer b.owner.mu.Unlock()
+
+	b.cache.Remove(key)
+	b.untag(key)
+}
+
+func (b *lruBucket) Purge() {
+	b.owner.mu.Lock()
+	defer b.owner.mu.Unlock()
+
+	b.cache.Purge()
+	for key := range b.keyTags {
+		b.untag(key)
+	}
+}
+
+// untag снимает теги ключа. Вызывается под owner.mu
+func (b *lruBucket) untag(key string) {
+	for _, tag := range b.keyTags[key] {
+		refs := b.owner.tags[tag]
+		delete(refs, entryRef{bucket: b.name, key: key})
+		if len(refs) == 0 {
+			delete(b.owner.tags, tag)
+		}
+	}
+	delete(b.keyTags, key)
+}
+
+// prune снимает теги с ключей, которые LRU уже вытеснил. Вызывается под owner.mu
+func (b *lruBucket) prune() {
+	for key := range b.keyTags {
+		if !b.cache.Contains(key) {
+			b.untag(key)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package cache
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRedisPrefix с чего начинаются ключи кэша в Redis
+	DefaultRedisPrefix = "cache:"
+	// redisTagTTL сколько живет множество ключей тега после последнего добавления. Ключи в нем
+	// истекают раньше, DEL уже истекшего ключа ничего не делает
+	redisTagTTL    = 24 * time.Hour
+	redisTimeout   = 2 * time.Second
+	redisMaxIdle   = 16
+	redisScanBatch = 500
+	// redisRetryMinDelay и redisRetryMaxDelay пауза между повторами несостоявшихся инвалидаций
+	redisRetryMinDelay = 100 * time.Millisecond
+	redisRetryMaxDelay = 10 * time.Second
+)
+
+// Redis кэш во внешнем хранилище с протоколом Redis, общий для всех реплик сервера.
+// Значения хранятся в JSON, поэтому Bucket.Get возвращает []byte. Емкость пространств имен
+// не учитывается, память ограничивают сроки записей и maxmemory самого Redis
+type Redis struct {
+	client *redisClient
+	prefix string
+
+	mu sync.Mutex
+	// pending теги, которые не удалось инвалидировать, с номером последней попытки.
+	// Их повторяет retryPending, пока Redis не ответит или его не закроют
+	pending   map[string]uint64
+	attempt   uint64
+	retrying  bool
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type RedisOption func(r *Redis)
+
+func WithRedisPassword(password string) RedisOption {
+	return func(r *Redis) {
+		r.client.password = password
+	}
+}
+
+func WithRedisDB(db int) RedisOption {
+	return func(r *Redis) {
+		r.client.db = db
+	}
+}
+
+// WithRedisPrefix разделяет несколько сервисов в одной базе Redis
+func WithRedisPrefix(prefix string) RedisOption {
+	return func(r *Redis) {
+		r.prefix = prefix
+	}
+}
+
+func WithRedisTimeout(timeout time.Duration) RedisOption {
+	return func(r *Redis) {
+		r.client.timeout = timeout
+	}
+}
+
+// NewRedis подключается к addr и проверяет соединение, чтобы сервер с неверным адресом
+// не запускался
+func NewRedis(addr string, opts ...RedisOption) (*Redis, error) {
+	r := &Redis{
+		client:  &redisClient{addr: addr, timeout: redisTimeout, maxIdle: redisMaxIdle},
+		prefix:  DefaultRedisPrefix,
+		pending: make(map[string]uint64),
+		closed:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if _, err := r.client.do("PING"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close останавливает и повторы инвалидаций, которые так и не прошли
+func (r *Redis) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+	r.client.close()
+}
+
+func (r *Redis) Bucket(name string, capacity int) Bucket {
+	return &redisBucket{owner: r, name: name, keyPrefix: r.prefix + "k:" + name + ":"}
+}
+
+// Invalidate в одной транзакции увеличивает поколение тега и читает множество его ключей:
+// Add, начатый до этого, уже не сохранит значение. Ключи удаляются вместе со своим членством
+// в множестве, поэтому ключ, добавленный после, остается помеченным тегом.
+// Тег, который не удалось инвалидировать, повторяется в фоне: иначе реплики отдавали бы
+// устаревшие записи до истечения их срока
+func (r *Redis) Invalidate(tags ...string) {
+	var failed []string
+	for _, tag := range tags {
+		if err := r.invalidate(tag); err != nil {
+			log.Printf("[cache] redis invalidate %s: %v, will retry", tag, err)
+			failed = append(failed, tag)
+		}
+	}
+	if len(failed) > 0 {
+		r.retryLater(failed)
+	}
+}
+
+func (r *Redis) invalidate(tag string) error {
+	genKey, tagKey := r.genKey(tag), r.tagKey(tag)
+	results, err := r.transaction([][]string{
+		{"INCR", genKey},
+		{"PEXPIRE", genKey, strconv.FormatInt(redisTagTTL.Milliseconds(), 10)},
+		{"SMEMBERS", tagKey},
+	})
+	if err != nil {
+		return err
+	}
+
+	// ключи, которые не удастся удалить, останутся в множестве и удалятся при повторе
+	keys := replyStrings(results[2])
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err = r.transaction([][]string{
+		append([]string{"DEL"}, keys...),
+		append([]string{"SREM", tagKey}, keys...),
+	})
+	return err
+}
+
+// transaction выполняет cmds между MULTI и EXEC и возвращает их ответы
+func (r *Redis) transaction(cmds [][]string) ([]interface{}, error) {
+	tx := append([][]string{{"MULTI"}}, cmds...)
+	replies, err := r.client.pipeline(append(tx, []string{"EXEC"}))
+	if err != nil {
+		return nil, err
+	}
+	if err := firstReplyError(replies); err != nil {
+		return nil, err
+	}
+	results, ok := replies[len(replies)-1].([]interface{})
+	if !ok || len(results) != len(cmds) {
+		return nil, errRedisProtocol
+	}
+	if err := firstReplyError(results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *Redis) retryLater(tags []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, tag := range tags {
+		r.attempt++
+		r.pending[tag] = r.attempt
+	}
+	if !r.retrying {
+		r.retrying = true
+		go r.retryPending()
+	}
+}
+
+// retryPending повторяет отложенные инвалидации с растущей паузой, пока все не пройдут
+func (r *Redis) retryPending() {
+	delay := redisRetryMinDelay
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-time.After(delay):
+		}
+
+		r.mu.Lock()
+		pending := make(map[string]uint64, len(r.pending))
+		for tag, attempt := range r.pending {
+			pending[tag] = attempt
+		}
+		r.mu.Unlock()
+
+		var err error
+		for tag, attempt := range pending {
+			if err = r.invalidate(tag); err != nil {
+				log.Printf("[cache] redis retry invalidate %s: %v", tag, err)
+				break
+			}
+			r.mu.Lock()
+			// тег, снова отложенный во время повтора, повторяется еще раз
+			if r.pending[tag] == attempt {
+				delete(r.pending, tag)
+			}
+			r.mu.Unlock()
+		}
+
+		r.mu.Lock()
+		if len(r.pending) == 0 {
+			r.retrying = false
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+
+		if err == nil {
+			delay = redisRetryMinDelay
+		} else if delay *= 2; delay > redisRetryMaxDelay {
+			delay = redisRetryMaxDelay
+		}
+	}
+}
+
+// Generation сумма поколений тегов. Если ключ поколения истек, сумма уменьшится и значение,
+// загруженное в это время, просто не сохранится
+func (r *Redis) Generation(tags ...string) (uint64, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+	reply, err := r.client.do(append([]string{"MGET"}, r.genKeys(tags)...)...)
+	if err != nil {
+		return 0, err
+	}
+	return sumGenerations(reply), nil
+}
+
+func (r *Redis) tagKey(tag string) string {
+	return r.prefix + "t:" + tag
+}
+
+func (r *Redis) genKey(tag string) string {
+	return r.prefix + "g:" + tag
+}
+
+func (r *Redis) genKeys(tags []string) []string {
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = r.genKey(tag)
+	}
+	return keys
+}
+
+type redisBucket struct {
+	owner     *Redis
+	name      string
+	keyPrefix string
+}
+
+// Get ошибки Redis считаются промахом, запрос тогда идет в хранилище
+func (b *redisBucket) Get(key string) (interface{}, bool) {
+	reply, err := b.owner.client.do("GET", b.keyPrefix+key)
+	if err != nil {
+		log.Printf("[cache] redis get %s/%s: %v", b.name, key, err)
+		return nil, false
+	}
+	data, ok := reply.([]byte)
+	return data, ok
+}
+
+// Add для записи с тегами следит за их поколениями через WATCH: если Invalidate увеличит поколение
+// между проверкой и записью, EXEC не выполнится
+func (b *redisBucket) Add(key string, value interface{}, tags []string, keep time.Duration, gen uint64) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("[cache] redis encode %s/%s: %v", b.name, key, err)
+		return
+	}
+
+	fullKey := b.keyPrefix + key
+	set := []string{"SET", fullKey, string(data)}
+	if keep > 0 {
+		set = append(set, "PX", strconv.FormatInt(keep.Milliseconds(), 10))
+	}
+	cmds := [][]string{set}
+	for _, tag := range tags {
+		tagKey := b.owner.tagKey(tag)
+		cmds = append(cmds,
+			[]string{"SADD", tagKey, fullKey},
+			[]string{"PEXPIRE", tagKey, strconv.FormatInt(redisTagTTL.Milliseconds(), 10)},
+		)
+	}
+	if len(tags) == 0 {
+		replies, err := b.owner.client.pipeline(cmds)
+		if err == nil {
+			err = firstReplyError(replies)
+		}
+		if err != nil {
+			log.Printf("[cache] redis add %s/%s: %v", b.name, key, err)
+		}
+		return
+	}
+
+	var replyErr error
+	err = b.owner.client.withConn(func(conn *redisConn) error {
+		genKeys := b.owner.genKeys(tags)
+		replies, err := conn.roundTrip([][]string{
+			append([]string{"WATCH"}, genKeys...),
+			append([]string{"MGET"}, genKeys...),
+		}, b.owner.client.timeout)
+		if err != nil {
+			return err
+		}
+		if replyErr = firstReplyError(replies); replyErr != nil || sumGenerations(replies[1]) != gen {
+			_, err := conn.roundTrip([][]string{{"UNWATCH"}}, b.owner.client.timeout)
+			return err
+		}
+
+		tx := append([][]string{{"MULTI"}}, cmds...)
+		replies, err = conn.roundTrip(append(tx, []string{"EXEC"}), b.owner.client.timeout)
+		if err != nil {
+			return err
+		}
+		// EXEC отвечает nil, если поколение изменилось: значение устарело и не сохраняется
+		replyErr = firstReplyError(replies)
+		return nil
+	})
+	if err == nil {
+		err = replyErr
+	}
+	if err != nil {
+		log.Printf("[cache] redis add %s/%s: %v", b.name, key, err)
+	}
+}
+
+func (b *redisBucket) Remove(key string) {
+	if _, err := b.owner.client.do("DEL", b.keyPrefix+key); err != nil {
+		log.Printf("[cache] redis remove %s/%s: %v", b.name, key, err)
+	}
+}
+
+// Purge удаляет ключи пространства имен через SCAN, не блокируя Redis на KEYS
+func (b *redisBucket) Purge() {
+	cursor := "0"
+	for {
+		reply, err := b.owner.client.do("SCAN", cursor, "MATCH", b.keyPrefix+"*", "COUNT", strconv.Itoa(redisScanBatch))
+		if err != nil {
+			log.Printf("[cache] redis purge %s: %v", b.name, err)
+			return
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			log.Printf("[cache] redis purge %s: %v", b.name, errRedisProtocol)
+			return
+		}
+		next, _ := parts[0].([]byte)
+		if keys := replyStrings(parts[1]); len(keys) > 0 {
+			if _, err := b.owner.client.do(append([]string{"DEL"}, keys...)...); err != nil {
+				log.Printf("[cache] redis purge %s: %v", b.name, err)
+				return
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+func replyStrings(reply interface{}) []string {
+	items, _ := reply.([]interface{})
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		if b, ok := item.([]byte); ok {
+			strs = append(strs, string(b))
+		}
+	}
+	return strs
+}
+
+// sumGenerations сумма ответа MGET по ключам поколений, отсутствующие ключи считаются нулем
+func sumGenerations(reply interface{}) uint64 {
+	var sum uint64
+	for _, value := range replyStrings(reply) {
+		n, _ := strconv.ParseUint(value, 10, 64)
+		sum += n
+	}
+	return sum
+}
+
+func firstReplyError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(redisError); ok {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,622 @@
+// Package redistest сервер с протоколом Redis в памяти процесса для проверки cache.Redis без
+// настоящего Redis. Поддерживает только команды, которые использует кэш
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Server struct {
+	listener net.Listener
+	password string
+
+	mu      sync.Mutex
+	strings map[string][]byte
+	sets    map[string]map[string]struct{}
+	expires map[string]time.Time
+	// versions меняются при каждом изменении ключа, по ним WATCH узнает, что ключ трогали
+	versions map[string]uint64
+	conns    map[net.Conn]struct{}
+	// failure ошибка, которой сервер отвечает на любую команду, пусто - работает как обычно
+	failure string
+	wg      sync.WaitGroup
+}
+
+// NewServer запускает сервер на свободном порту 127.0.0.1
+func NewServer() (*Server, error) {
+	return NewServerWithPassword("")
+}
+
+// NewServerWithPassword как NewServer, но требует AUTH password перед остальными командами
+func NewServerWithPassword(password string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		password: password,
+		strings:  make(map[string][]byte),
+		sets:     make(map[string]map[string]struct{}),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]uint64),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close останавливает сервер и разрывает открытые соединения
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// FailWith заставляет сервер отвечать ошибкой msg на все команды, пустая msg возвращает обычную работу
+func (s *Server) FailWith(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = msg
+}
+
+func (s *Server) failing() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failure
+}
+
+// Keys ключи с неистекшим сроком, по возрастанию
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for key := range s.keySet() {
+		if !s.expired(key, now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TTL оставшийся срок ключа, 0 - без срока или ключа нет
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.expires[key]
+	if !ok {
+		return 0
+	}
+	return time.Until(at)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	authorized := s.password == ""
+	// состояние транзакции соединения: команды после MULTI и версии ключей из WATCH
+	var (
+		inMulti bool
+		queued  [][]string
+		watched map[string]uint64
+	)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cmd := strings.ToUpper(args[0])
+		failure := s.failing()
+		switch {
+		case failure != "":
+			writeError(w, failure)
+		case cmd == "AUTH":
+			if len(args) == 2 && args[1] == s.password {
+				authorized = true
+				writeSimple(w, "OK")
+			} else {
+				writeError(w, "WRONGPASS invalid username-password pair")
+			}
+		case !authorized:
+			writeError(w, "NOAUTH Authentication required.")
+		case cmd == "MULTI":
+			if inMulti {
+				writeError(w, "ERR MULTI calls can not be nested")
+				break
+			}
+			inMulti, queued = true, nil
+			writeSimple(w, "OK")
+		case cmd == "EXEC":
+			if !inMulti {
+				writeError(w, "ERR EXEC without MULTI")
+				break
+			}
+			s.execTx(w, queued, watched)
+			inMulti, queued, watched = false, nil, nil
+		case cmd == "DISCARD":
+			if !inMulti {
+				writeError(w, "ERR DISCARD without MULTI")
+				break
+			}
+			inMulti, queued, watched = false, nil, nil
+			writeSimple(w, "OK")
+		case inMulti && cmd == "WATCH":
+			writeError(w, "ERR WATCH inside MULTI is not allowed")
+		case inMulti:
+			queued = append(queued, args)
+			writeSimple(w, "QUEUED")
+		case cmd == "WATCH":
+			if len(args) < 2 {
+				writeArgsError(w, cmd)
+				break
+			}
+			if watched == nil {
+				watched = make(map[string]uint64)
+			}
+			s.watch(watched, args[1:])
+			writeSimple(w, "OK")
+		case cmd == "UNWATCH":
+			watched = nil
+			writeSimple(w, "OK")
+		default:
+			s.exec(w, cmd, args[1:])
+		}
+		// ответы на команды одного пакета отправляются вместе
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) watch(watched map[string]uint64, keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if s.expired(key, now) {
+			s.delete(key)
+		}
+		watched[key] = s.versions[key]
+	}
+}
+
+// execTx выполняет команды транзакции целиком или, если ключи из WATCH менялись, отвечает nil
+func (s *Server) execTx(w *bufio.Writer, queued [][]string, watched map[string]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, version := range watched {
+		if s.expired(key, now) {
+			s.delete(key)
+		}
+		if s.versions[key] != version {
+			fmt.Fprint(w, "*-1\r\n")
+			return
+		}
+	}
+	fmt.Fprintf(w, "*%d\r\n", len(queued))
+	for _, args := range queued {
+		s.execLocked(w, strings.ToUpper(args[0]), args[1:])
+	}
+}
+
+func (s *Server) exec(w *bufio.Writer, cmd string, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.execLocked(w, cmd, args)
+}
+
+// execLocked выполняет одну команду под s.mu
+func (s *Server) execLocked(w *bufio.Writer, cmd string, args []string) {
+	now := time.Now()
+	for _, key := range args {
+		// ленивое истечение: ключ удаляется, когда к нему обращаются
+		if s.expired(key, now) {
+			s.delete(key)
+		}
+	}
+
+	switch cmd {
+	case "PING":
+		writeSimple(w, "PONG")
+	case "SELECT":
+		writeSimple(w, "OK")
+	case "GET":
+		if len(args) != 1 {
+			writeArgsError(w, cmd)
+			return
+		}
+		if _, ok := s.sets[args[0]]; ok {
+			writeError(w, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		value, ok := s.strings[args[0]]
+		if !ok {
+			writeNil(w)
+			return
+		}
+		writeBulk(w, value)
+	case "SET":
+		if len(args) != 2 && len(args) != 4 {
+			writeArgsError(w, cmd)
+			return
+		}
+		var ttl time.Duration
+		if len(args) == 4 {
+			n, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			switch strings.ToUpper(args[2]) {
+			case "PX":
+				ttl = time.Duration(n) * time.Millisecond
+			case "EX":
+				ttl = time.Duration(n) * time.Second
+			default:
+				writeError(w, "ERR syntax error")
+				return
+			}
+		}
+		s.delete(args[0])
+		s.strings[args[0]] = []byte(args[1])
+		s.touch(args[0])
+		if ttl > 0 {
+			s.expires[args[0]] = now.Add(ttl)
+		}
+		writeSimple(w, "OK")
+	case "DEL", "EXISTS":
+		if len(args) == 0 {
+			writeArgsError(w, cmd)
+			return
+		}
+		var n int64
+		for _, key := range args {
+			if s.exists(key) {
+				n++
+				if cmd == "DEL" {
+					s.delete(key)
+				}
+			}
+		}
+		writeInt(w, n)
+	case "PEXPIRE":
+		if len(args) != 2 {
+			writeArgsError(w, cmd)
+			return
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			writeError(w, "ERR value is not an integer or out of range")
+			return
+		}
+		if !s.exists(args[0]) {
+			writeInt(w, 0)
+			return
+		}
+		s.expires[args[0]] = now.Add(time.Duration(ms) * time.Millisecond)
+		s.touch(args[0])
+		writeInt(w, 1)
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			writeArgsError(w, cmd)
+			return
+		}
+		if _, ok := s.strings[args[0]]; ok {
+			writeError(w, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		set, ok := s.sets[args[0]]
+		if !ok {
+			set = make(map[string]struct{})
+			s.sets[args[0]] = set
+		}
+		var n int64
+		for _, member := range args[1:] {
+			_, had := set[member]
+			if cmd == "SADD" && !had {
+				set[member] = struct{}{}
+				n++
+			}
+			if cmd == "SREM" && had {
+				delete(set, member)
+				n++
+			}
+		}
+		if n > 0 {
+			s.touch(args[0])
+		}
+		if len(set) == 0 {
+			s.delete(args[0])
+		}
+		writeInt(w, n)
+	case "INCR":
+		if len(args) != 1 {
+			writeArgsError(w, cmd)
+			return
+		}
+		if _, ok := s.sets[args[0]]; ok {
+			writeError(w, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		var n int64
+		if value, ok := s.strings[args[0]]; ok {
+			var err error
+			if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				writeError(w, "ERR value is not an integer or out of range")
+				return
+			}
+		}
+		n++
+		s.strings[args[0]] = []byte(strconv.FormatInt(n, 10))
+		s.touch(args[0])
+		writeInt(w, n)
+	case "MGET":
+		if len(args) == 0 {
+			writeArgsError(w, cmd)
+			return
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(args))
+		for _, key := range args {
+			if value, ok := s.strings[key]; ok {
+				writeBulk(w, value)
+			} else {
+				writeNil(w)
+			}
+		}
+	case "SMEMBERS":
+		if len(args) != 1 {
+			writeArgsError(w, cmd)
+			return
+		}
+		members := make([]string, 0, len(s.sets[args[0]]))
+		for member := range s.sets[args[0]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		writeArray(w, members)
+	case "SCAN":
+		s.scan(w, args, now)
+	case "FLUSHDB", "FLUSHALL":
+		for key := range s.keySet() {
+			s.touch(key)
+		}
+		s.strings = make(map[string][]byte)
+		s.sets = make(map[string]map[string]struct{})
+		s.expires = make(map[string]time.Time)
+		writeSimple(w, "OK")
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+	}
+}
+
+// scan отдает все подходящие ключи за один проход, курсор всегда 0
+func (s *Server) scan(w *bufio.Writer, args []string, now time.Time) {
+	if len(args) == 0 || len(args)%2 != 1 {
+		writeArgsError(w, "SCAN")
+		return
+	}
+	pattern := "*"
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+
+	var keys []string
+	for key := range s.keySet() {
+		if s.expired(key, now) {
+			s.delete(key)
+			continue
+		}
+		if match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	fmt.Fprint(w, "*2\r\n")
+	writeBulk(w, []byte("0"))
+	writeArray(w, keys)
+}
+
+func (s *Server) keySet() map[string]bool {
+	keys := make(map[string]bool, len(s.strings)+len(s.sets))
+	for key := range s.strings {
+		keys[key] = true
+	}
+	for key := range s.sets {
+		keys[key] = true
+	}
+	return keys
+}
+
+func (s *Server) exists(key string) bool {
+	_, isString := s.strings[key]
+	_, isSet := s.sets[key]
+	return isString || isSet
+}
+
+func (s *Server) expired(key string, now time.Time) bool {
+	at, ok := s.expires[key]
+	return ok && !now.Before(at)
+}
+
+func (s *Server) delete(key string) {
+	if s.exists(key) {
+		s.touch(key)
+	}
+	delete(s.strings, key)
+	delete(s.sets, key)
+	delete(s.expires, key)
+}
+
+func (s *Server) touch(key string) {
+	s.versions[key]++
+}
+
+// match glob Redis: * и ? без классов символов
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+var errProtocol = errors.New("redistest: protocol error")
+
+// readCommand читает команду как массив bulk строк, inline команды не поддерживаются
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, errProtocol
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, errProtocol
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errProtocol
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	fmt.Fprintf(w, "-%s\r\n", msg)
+}
+
+func writeArgsError(w *bufio.Writer, cmd string) {
+	writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func writeNil(w *bufio.Writer) {
+	fmt.Fprint(w, "$-1\r\n")
+}
+
+func writeBulk(w *bufio.Writer, b []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	fmt.Fprint(w, "\r\n")
+}
+
+func writeArray(w *bufio.Writer, items []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(items))
+	for _, item := range items {
+		writeBulk(w, []byte(item))
+	}
+}
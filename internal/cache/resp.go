@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisError ответ Redis с ошибкой (-ERR ...)
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+var errRedisProtocol = errors.New("redis: protocol error")
+
+// redisClient клиент протокола RESP2 с пулом соединений
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	maxIdle  int
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// do выполняет одну команду. Ответ: string, int64, []byte, []interface{} или nil
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	replies, err := c.pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(redisError); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline отправляет команды одним пакетом и читает ответы. Ошибки отдельных команд
+// возвращаются в ответах как redisError. Команды кэша можно повторять, поэтому при обрыве
+// соединения из пула (например, после перезапуска Redis) они повторяются на новом
+func (c *redisClient) pipeline(cmds [][]string) ([]interface{}, error) {
+	var replies []interface{}
+	err := c.withConn(func(conn *redisConn) error {
+		var err error
+		replies, err = conn.roundTrip(cmds, c.timeout)
+		return err
+	})
+	return replies, err
+}
+
+// withConn выполняет fn на одном соединении, например WATCH и MULTI/EXEC. fn возвращает только
+// ошибки соединения, после них оно закрывается, а fn на соединении из пула повторяется один раз
+func (c *redisClient) withConn(fn func(conn *redisConn) error) error {
+	conn, pooled, err := c.get()
+	if err != nil {
+		return err
+	}
+	err = fn(conn)
+	if err != nil && pooled {
+		conn.conn.Close()
+		if conn, err = c.dial(); err != nil {
+			return err
+		}
+		err = fn(conn)
+	}
+	if err != nil {
+		conn.conn.Close()
+		return err
+	}
+	c.put(conn)
+	return nil
+}
+
+// get соединение из пула или новое, pooled - взято из пула
+func (c *redisClient) get() (conn *redisConn, pooled bool, err error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, true, nil
+	}
+	c.mu.Unlock()
+
+	conn, err = c.dial()
+	return conn, false, err
+}
+
+// dial открывает соединение, авторизуется и выбирает базу
+func (c *redisClient) dial() (*redisConn, error) {
+
+	netConn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) > 0 {
+		replies, err := conn.roundTrip(setup, c.timeout)
+		if err == nil {
+			for _, reply := range replies {
+				if replyErr, ok := reply.(redisError); ok {
+					err = replyErr
+				}
+			}
+		}
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *redisClient) put(conn *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle) >= c.maxIdle {
+		conn.conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+func (c *redisClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, conn := range c.idle {
+		conn.conn.Close()
+	}
+	c.idle = nil
+}
+
+func (c *redisConn) roundTrip(cmds [][]string, timeout time.Duration) ([]interface{}, error) {
+	if timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+	for _, args := range cmds {
+		if err := writeCommand(c.w, args); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := readReply(c.r)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// writeCommand команда как массив bulk строк: *2\r\n$3\r\nGET\r\n$1\r\nk\r\n
+func writeCommand(w *bufio.Writer, args []string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.WriteString(arg)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply читает один ответ RESP2. Ошибка Redis возвращается значением redisError
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errRedisProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, errRedisProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, errRedisProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, errRedisProtocol
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errRedisProtocol
+	}
+	return line[:len(line)-2], nil
+}
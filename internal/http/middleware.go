@@ -2,35 +2,99 @@ package http
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
+	"project/internal/models"
 	"project/internal/pkg"
+	"project/internal/pkg/apierror"
+	"project/internal/pkg/auth"
+	"project/internal/store"
 	"strings"
+	"time"
 )
 
-const authorizationHeader = "Authorization"
+const (
+	authorizationHeader = "Authorization"
+	apiKeyHeader        = "X-API-Key"
+	apiKeyScheme        = "ApiKey"
+	// apiKeyTouchInterval last_used_at обновляется не чаще, чтобы частые запросы интеграций не писали в базу каждый раз
+	apiKeyTouchInterval = time.Minute
+)
 
 func (s *Server) userIdentity(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get(authorizationHeader)
+		if key := r.Header.Get(apiKeyHeader); key != "" {
+			s.apiKeyIdentity(w, r, next, key)
+			return
+		}
+		if scheme, key, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, apiKeyScheme) {
+			s.apiKeyIdentity(w, r, next, key)
+			return
+		}
 		if header == "" {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, "empty authorization header")
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Empty authorization header", nil)
 			return
 		}
 		headerParts := strings.Split(header, " ")
 		if len(headerParts) != 2 {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, "invalid authorization header")
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Invalid authorization header", nil)
 			return
 		}
 		userInfo, err := s.tokenManager.Parse(headerParts[1])
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, "Unknown err : %v", err)
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Invalid or expired token", nil)
 			return
 		}
+		if userInfo.TokenID != "" {
+			revoked, err := s.store.RevokedTokens().Contains(r.Context(), userInfo.TokenID)
+			if err != nil {
+				log.Printf("[auth] check token revocation: %v", err)
+				apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+				return
+			}
+			if revoked {
+				apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Token has been revoked", nil)
+				return
+			}
+		}
 
 		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pkg.CtxKeyUser, userInfo)))
 	})
 }
+
+// apiKeyIdentity пропускает запрос с ключом интеграции. Права ключа ограничены текущей
+// ролью владельца, поэтому понижение роли сразу сужает и выпущенные ключи
+func (s *Server) apiKeyIdentity(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	apiKey, err := s.store.APIKeys().ByHash(r.Context(), auth.HashToken(strings.TrimSpace(key)))
+	if errors.Is(err, store.ErrNotFound) {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Invalid or revoked API key", nil)
+		return
+	}
+	var user *models.User
+	if err == nil {
+		user, err = s.store.Users().ByID(r.Context(), apiKey.UserID)
+	}
+	if err != nil {
+		log.Printf("[auth] resolve api key: %v", err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.store.APIKeys().Touch(r.Context(), apiKey.ID, now); err != nil {
+			log.Printf("[auth] touch api key %d: %v", apiKey.ID, err)
+		}
+	}
+
+	role := user.GetRole()
+	userInfo := &models.AuthorizedInfo{
+		Id:          user.ID,
+		Role:        role,
+		Permissions: apiKey.Scopes.Intersect(role.Permissions()),
+		APIKeyID:    apiKey.ID,
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pkg.CtxKeyUser, userInfo)))
+}
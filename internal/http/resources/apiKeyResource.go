@@ -0,0 +1,124 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"net/http"
+	"project/internal/models"
+	"project/internal/pkg"
+	"project/internal/pkg/apierror"
+	"project/internal/pkg/auth"
+	"project/internal/pkg/policy"
+	"project/internal/store"
+	"strconv"
+	"time"
+)
+
+// APIKeyResource ключи для интеграций дилеров, которые не могут пройти интерактивный логин
+type APIKeyResource struct {
+	store store.Store
+}
+
+func NewAPIKeyResource(store store.Store) *APIKeyResource {
+	return &APIKeyResource{store: store}
+}
+
+func (ar *APIKeyResource) Routes(auth func(handler http.Handler) http.Handler) chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(auth, requireInteractive)
+	r.Post("/", ar.CreateAPIKey)
+	r.Get("/", ar.APIKeys)
+	r.Delete("/{id:[0-9]+}", ar.RevokeAPIKey)
+	return r
+}
+
+// requireInteractive пускает только с JWT: ключами, сессиями, паролем и 2FA нельзя управлять
+// с помощью ключа, иначе утекший ключ давал бы весь аккаунт
+func requireInteractive(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+		if userInfo.APIKeyID != 0 {
+			apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, "This action requires a login, API keys are not accepted", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CreateAPIKey выпускает ключ. Сам ключ возвращается только в этом ответе
+func (ar *APIKeyResource) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+
+	body := new(models.CreateAPIKeyDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+	if err := body.Validate(); err != nil {
+		apierror.Validation(w, r, err)
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !userInfo.Can(scope) {
+			apierror.Write(w, r, http.StatusForbidden, apierror.CodeInsufficientRights,
+				fmt.Sprintf("Cannot grant %s: you don't have this permission", scope), nil)
+			return
+		}
+	}
+
+	key, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	apiKey := &models.APIKey{
+		UserID:  userInfo.Id,
+		Name:    body.Name,
+		Prefix:  prefix,
+		KeyHash: auth.HashToken(key),
+		Scopes:  body.Scopes,
+	}
+	if err := ar.store.APIKeys().Create(r.Context(), apiKey); err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.JSON(w, r, &models.APIKeyCreated{Key: key, APIKey: apiKey})
+}
+
+// APIKeys ключи текущего пользователя, включая отозванные
+func (ar *APIKeyResource) APIKeys(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+
+	keys, err := ar.store.APIKeys().AllOfUser(r.Context(), userInfo.Id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	render.JSON(w, r, keys)
+}
+
+func (ar *APIKeyResource) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+		return
+	}
+	apiKey, err := ar.store.APIKeys().ByID(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !authorize(w, r, policy.Delete, apiKey) {
+		return
+	}
+	if err := ar.store.APIKeys().Revoke(r.Context(), apiKey.ID, time.Now()); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
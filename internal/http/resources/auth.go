@@ -1,42 +1,119 @@
 package resources
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
-	lru "github.com/hashicorp/golang-lru"
+	"log"
+	"net"
 	"net/http"
 	"project/internal/models"
+	"project/internal/pkg"
+	"project/internal/pkg/apierror"
 	"project/internal/pkg/auth"
+	"project/internal/pkg/limiter"
+	"project/internal/pkg/mail"
+	"project/internal/pkg/oidc"
+	"project/internal/pkg/policy"
 	"project/internal/store"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	accessTokenTTL  = 2 * time.Hour
-	refreshTokenTTL = 168 * time.Hour
+	accessTokenTTL   = 2 * time.Hour
+	refreshTokenTTL  = 168 * time.Hour
+	passwordResetTTL = time.Hour
+	// passwordResetInterval не чаще скольких писем со сбросом пароля отправляется одному пользователю
+	passwordResetInterval = time.Minute
+	// forgotPerIP и forgotWindow сколько запросов сброса пароля принимается с одного адреса
+	forgotPerIP  = 10
+	forgotWindow = time.Hour
 )
 
 type AuthResource struct {
 	store        store.Store
-	cache        *lru.TwoQueueCache
 	tokenManager auth.TokenManager
+	mailer       mail.Mailer
+	publicURL    string
+	forgotLimit  *throttle
+	verification *EmailVerification
+	ipLimiter    limiter.Limiter
+	emailLimiter limiter.Limiter
+	challenges   *auth.LinkSigner
+	issuer       string
+	oidc         *oidc.Provider
 }
 
-func NewAuthResource(store store.Store, cache *lru.TwoQueueCache, tokenManager auth.TokenManager) *AuthResource {
-	return &AuthResource{
+type AuthOption func(a *AuthResource)
+
+// WithMailer задает отправку писем и адрес, от которого строятся ссылки в письмах
+func WithMailer(mailer mail.Mailer, publicURL string) AuthOption {
+	return func(a *AuthResource) {
+		a.mailer = mailer
+		a.publicURL = strings.TrimRight(publicURL, "/")
+	}
+}
+
+// WithEmailVerification включает подтверждение email и повторную отправку письма
+func WithEmailVerification(verification *EmailVerification) AuthOption {
+	return func(a *AuthResource) {
+		a.verification = verification
+	}
+}
+
+func NewAuthResource(store store.Store, tokenManager auth.TokenManager, opts ...AuthOption) *AuthResource {
+	a := &AuthResource{
 		store:        store,
-		cache:        cache,
 		tokenManager: tokenManager,
+		mailer:       mail.LogMailer{},
+		forgotLimit:  newThrottle(forgotPerIP, forgotWindow),
+		ipLimiter:    limiter.NewMemory(limiter.DefaultIPPolicy),
+		emailLimiter: limiter.NewMemory(limiter.DefaultEmailPolicy),
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	return a
 }
 
-func (a *AuthResource) Routes() chi.Router {
+func (a *AuthResource) Routes(auth func(handler http.Handler) http.Handler) chi.Router {
 	r := chi.NewRouter()
 
 	r.Post("/login", a.LoginUser)
+	r.Post("/login/2fa", a.LoginTwoFactor)
+	r.Post("/login/2fa/enroll", a.LoginEnroll)
+	r.Post("/refresh", a.Refresh)
+	r.Post("/password/forgot", a.ForgotPassword)
+	r.Post("/password/reset", a.ResetPassword)
+	if a.verification != nil {
+		r.Get("/verify", a.VerifyEmail)
+	}
+	if a.oidc != nil {
+		r.Get("/oidc/login", a.OIDCLogin)
+		r.Get("/oidc/callback", a.OIDCCallback)
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(auth)
+		if a.verification != nil {
+			r.Post("/verify/resend", a.ResendVerification)
+		}
+		r.With(requireInteractive).Post("/logout", a.Logout)
+		r.With(RequirePermission(models.PermUsersManage)).Post("/unlock", a.UnlockLogin)
+		r.With(requireInteractive).Get("/sessions", a.Sessions)
+		r.With(requireInteractive).Delete("/sessions/{id:[0-9]+}", a.RevokeSession)
+		r.Route("/2fa", func(r chi.Router) {
+			r.Use(requireInteractive)
+			r.Post("/enroll", a.EnrollTwoFactor)
+			r.Post("/confirm", a.ConfirmTwoFactor)
+			r.Post("/recovery-codes", a.RegenerateRecoveryCodes)
+			r.Post("/disable", a.DisableTwoFactor)
+		})
+	})
 	return r
 }
 
@@ -44,69 +121,413 @@ func (a *AuthResource) LoginUser(w http.ResponseWriter, r *http.Request) {
 	user := new(models.LogInDTO)
 
 	if err := json.NewDecoder(r.Body).Decode(user); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown error: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+
+	// с таким адресом пользователя быть не может, а ключ счетчика с ним не поместится в хранилище
+	if len(user.Email) > models.MaxEmailLength {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Incorrect email or password", nil)
+		return
+	}
+
+	ip := clientIP(r)
+	retryAfter, err := a.checkLogin(r.Context(), ip, user.Email)
+	if err != nil {
+		log.Printf("[auth] check login limits: %v", err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+	if retryAfter > 0 {
+		tooManyRequests(w, r, retryAfter, "Too many failed login attempts, try again later")
 		return
 	}
 
 	u, err := a.store.Users().ByEmail(r.Context(), user.Email)
 	if err != nil || !u.ComparePassword(user.Password) {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprintf(w, "Incorrect email or password")
+		a.loginFailed(r.Context(), ip, user.Email)
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Incorrect email or password", nil)
+		return
+	}
+
+	// счетчик неудачных входов сбрасывается только после второго фактора, иначе
+	// знающий пароль мог бы перебирать коды без ограничений
+	step, err := a.twoFactorStep(r.Context(), u)
+	if err != nil {
+		storeError(w, r, err)
 		return
 	}
+	if step != "" {
+		a.loginChallenge(w, r, u, step)
+		return
+	}
+	a.loginSucceeded(r.Context(), ip, user.Email)
 
-	tokens, err := a.CreateSession(&models.AuthorizedInfo{
+	tokens, err := a.CreateSession(r.Context(), &models.AuthorizedInfo{
 		Id:   u.ID,
-		Role: *u.Role,
-	})
+		Role: u.GetRole(),
+	}, r.UserAgent(), ip)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Unknown error: %v", err)
+		log.Printf("[auth] create session: %v", err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
 		return
 	}
 	render.JSON(w, r, tokens)
 
 }
 
-func (a *AuthResource) CreateSession(userInfo *models.AuthorizedInfo) (*models.Tokens, error) {
-	var token models.Tokens
-	var err error
+// Refresh меняет refresh токен на новую пару токенов. Старый токен после этого недействителен
+func (a *AuthResource) Refresh(w http.ResponseWriter, r *http.Request) {
+	body := new(models.RefreshDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+	if body.RefreshToken == "" {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "refresh_token is required", nil)
+		return
+	}
 
-	if token.AccessToken, err = a.tokenManager.NewJWT(userInfo, accessTokenTTL); err != nil {
-		return nil, err
+	tokens, err := a.RefreshTokens(r.Context(), body.RefreshToken)
+	switch {
+	case errors.Is(err, store.ErrTokenReused):
+		log.Printf("[auth] refresh token reuse detected, session and its access token revoked")
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeTokenReused, "Refresh token was already used, session revoked", nil)
+	case errors.Is(err, store.ErrNotFound):
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid or expired refresh token", nil)
+	case errors.Is(err, ErrTwoFactorRequired):
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeTwoFactorRequired, "Two-factor authentication is required, log in again to enable it", nil)
+	case err != nil:
+		log.Printf("[auth] refresh tokens: %v", err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+	default:
+		render.JSON(w, r, tokens)
 	}
+}
+
+// Logout закрывает текущую сессию и отзывает access токен, с которым пришел запрос
+func (a *AuthResource) Logout(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+
+	if userInfo.SessionID != 0 {
+		session, err := a.store.Sessions().ByID(r.Context(), userInfo.SessionID)
+		if err == nil {
+			err = a.revokeSession(r.Context(), session)
+		}
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			storeError(w, r, err)
+			return
+		}
+	}
+	if userInfo.TokenID != "" {
+		if err := a.store.RevokedTokens().Add(r.Context(), userInfo.TokenID, userInfo.ExpiresAt); err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	token.RefreshToken, err = a.tokenManager.NewRefreshToken()
+// Sessions список активных устройств текущего пользователя
+func (a *AuthResource) Sessions(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
 
+	sessions, err := a.store.Sessions().ActiveOfUser(r.Context(), userInfo.Id)
 	if err != nil {
-		return nil, err
+		storeError(w, r, err)
+		return
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == userInfo.SessionID
+	}
+	render.JSON(w, r, sessions)
+}
+
+func (a *AuthResource) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+		return
+	}
+	session, err := a.store.Sessions().ByID(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !authorize(w, r, policy.Delete, session) {
+		return
+	}
+	if err := a.revokeSession(r.Context(), session); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotPassword отправляет ссылку для сброса пароля. Ответ не зависит от того,
+// есть ли пользователь с таким email, и от того, отправлено ли письмо
+func (a *AuthResource) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if retryAfter := a.forgotLimit.allow(clientIP(r), time.Now()); retryAfter > 0 {
+		tooManyRequests(w, r, retryAfter, "Too many password reset requests, try again later")
+		return
 	}
 
-	session := models.Session{
-		RefreshToken: token.RefreshToken,
-		ExpiresAt:    time.Now().Add(refreshTokenTTL),
+	body := new(models.ForgotPasswordDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+	if err := body.Validate(); err != nil {
+		apierror.Validation(w, r, err)
+		return
 	}
 
-	a.cache.Add(userInfo.Id, session)
-	return &token, err
+	user, err := a.store.Users().ByEmail(r.Context(), body.Email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		storeError(w, r, err)
+		return
+	default:
+		if err := a.sendPasswordReset(r.Context(), user); err != nil {
+			log.Printf("[auth] password reset for user %d: %v", user.ID, err)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
 }
 
-func (a *AuthResource) RefreshTokens(userInfo *models.AuthorizedInfo, refreshToken string) (*models.Tokens, error) {
-	tokenRaw, ok := a.cache.Get(userInfo.Id)
-	if !ok {
-		return nil, errors.New("Cache error : user not registered")
+func (a *AuthResource) sendPasswordReset(ctx context.Context, user *models.User) error {
+	token, err := a.tokenManager.NewRefreshToken()
+	if err != nil {
+		return err
+	}
+	reset := &models.PasswordReset{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	err = a.store.PasswordResets().Create(ctx, reset, time.Now().Add(-passwordResetInterval))
+	if errors.Is(err, store.ErrConflict) {
+		// письмо уже уходило недавно, повторно не шлем
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	token, ok := tokenRaw.(models.Session)
+	return a.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Someone requested a password reset for your account.\n\n"+
+			"To choose a new password, send the token below to %s/auth/password/reset within %s:\n\n%s\n\n"+
+			"If it wasn't you, ignore this email.\n", a.publicURL, passwordResetTTL, token),
+	})
+}
 
-	if !ok {
-		return nil, errors.New("refresh token err")
+// ResetPassword меняет пароль по токену из письма и закрывает все сессии пользователя
+func (a *AuthResource) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	body := new(models.ResetPasswordDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+	if err := body.Validate(); err != nil {
+		apierror.Validation(w, r, err)
+		return
 	}
 
-	if token.RefreshToken != refreshToken && time.Now().Unix() > token.ExpiresAt.Unix() {
-		return nil, errors.New("refresh token err expired")
+	reset, err := a.store.PasswordResets().Consume(r.Context(), auth.HashToken(body.Token), time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidResetToken, "Invalid or expired reset token", nil)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
 
-	return a.CreateSession(userInfo)
+	user := &models.User{ID: reset.UserID, Password: body.Password}
+	if err := user.BeforeCreating(); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if err := a.store.Users().UpdatePassword(r.Context(), user); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if err := revokeAllSessions(r.Context(), a.store, user.ID); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AuthResource) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	err := a.verification.Verify(r.Context(), r.URL.Query().Get("token"))
+	switch {
+	case errors.Is(err, auth.ErrInvalidLink), errors.Is(err, store.ErrNotFound):
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidLink, "Invalid verification link", nil)
+	case errors.Is(err, auth.ErrExpiredLink):
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidLink, "Verification link expired, request a new one", nil)
+	case err != nil:
+		storeError(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResendVerification повторно отправляет письмо, не чаще раза в verificationResendInterval
+func (a *AuthResource) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+
+	user, err := a.store.Users().ByID(r.Context(), userInfo.Id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if user.Verified() {
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeAlreadyVerified, "Email already verified", nil)
+		return
+	}
+
+	err = a.verification.Send(r.Context(), user)
+	if errors.Is(err, store.ErrNotFound) {
+		tooManyRequests(w, r, a.verification.retryAfter(user), "Verification email was sent recently")
+		return
+	}
+	if err != nil {
+		log.Printf("[auth] resend verification to user %d: %v", user.ID, err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// revokeSession отзывает сессию и последний выданный ей access токен
+func (a *AuthResource) revokeSession(ctx context.Context, session *models.Session) error {
+	if err := a.store.Sessions().Revoke(ctx, session.ID, time.Now()); err != nil {
+		return err
+	}
+	if session.AccessTokenID == "" {
+		return nil
+	}
+	return a.store.RevokedTokens().Add(ctx, session.AccessTokenID, time.Now().Add(accessTokenTTL))
+}
+
+// revokeAllSessions отзывает все сессии пользователя вместе с их access токенами: после смены
+// пароля или удаления аккаунта старые токены не должны работать
+func revokeAllSessions(ctx context.Context, store store.Store, userID int) error {
+	sessions, err := store.Sessions().RevokeAllOfUser(ctx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.AccessTokenID == "" {
+			continue
+		}
+		if err := store.RevokedTokens().Add(ctx, session.AccessTokenID, time.Now().Add(accessTokenTTL)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateSession открывает новую сессию пользователя и выдает первую пару токенов
+func (a *AuthResource) CreateSession(ctx context.Context, userInfo *models.AuthorizedInfo, userAgent, ip string) (*models.Tokens, error) {
+	refreshToken, err := a.tokenManager.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	if userInfo.TokenID, err = auth.NewTokenID(); err != nil {
+		return nil, err
+	}
+	userInfo.Permissions = userInfo.Role.Permissions()
+
+	expiresAt := time.Now().Add(refreshTokenTTL)
+	session := &models.Session{
+		UserID:        userInfo.Id,
+		UserAgent:     userAgent,
+		IP:            ip,
+		ExpiresAt:     expiresAt,
+		AccessTokenID: userInfo.TokenID,
+	}
+	token := &models.RefreshToken{Hash: auth.HashToken(refreshToken), ExpiresAt: expiresAt}
+	if err := a.store.Sessions().Create(ctx, session, token); err != nil {
+		return nil, err
+	}
+
+	userInfo.SessionID = session.ID
+	accessToken, err := a.tokenManager.NewJWT(userInfo, accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Tokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// RefreshTokens ротирует refresh токен. Роль перечитывается из базы, чтобы новый
+// access токен не нес устаревших прав
+func (a *AuthResource) RefreshTokens(ctx context.Context, refreshToken string) (*models.Tokens, error) {
+	next, err := a.tokenManager.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	tokenID, err := auth.NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := a.store.Sessions().Rotate(ctx, auth.HashToken(refreshToken), &models.RefreshToken{
+		Hash:      auth.HashToken(next),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}, tokenID)
+	if errors.Is(err, store.ErrTokenReused) && session != nil && session.AccessTokenID != "" {
+		// токен мог попасть к злоумышленнику вместе с последним access токеном сессии
+		if err := a.store.RevokedTokens().Add(ctx, session.AccessTokenID, time.Now().Add(accessTokenTTL)); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.store.Users().ByID(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	role := user.GetRole()
+	if step, err := a.twoFactorStep(ctx, user); err != nil {
+		return nil, err
+	} else if step == twoFactorStepEnroll {
+		// сессия открыта до того, как роль стала требовать 2FA: дальше только через новый вход
+		if err := a.revokeSession(ctx, session); err != nil {
+			return nil, err
+		}
+		return nil, ErrTwoFactorRequired
+	}
+	accessToken, err := a.tokenManager.NewJWT(&models.AuthorizedInfo{
+		Id:          user.ID,
+		Role:        role,
+		Permissions: role.Permissions(),
+		SessionID:   session.ID,
+		TokenID:     tokenID,
+	}, accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Tokens{AccessToken: accessToken, RefreshToken: next}, nil
+}
+
+// JWKS публикует открытые ключи, чтобы другие сервисы проверяли токены без обращения к нам
+func (a *AuthResource) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	render.JSON(w, r, a.tokenManager.JWKS())
+}
+
+// clientIP адрес клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -1,28 +1,43 @@
 package resources
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	validation "github.com/go-ozzo/ozzo-validation"
-	lru "github.com/hashicorp/golang-lru"
 	"net/http"
+	"project/internal/cache"
 	"project/internal/models"
-	"project/internal/pkg"
+	"project/internal/pkg/apierror"
 	"project/internal/store"
 	"strconv"
+	"time"
+)
+
+const (
+	brandCacheSize     = 128
+	brandListCacheSize = 64
+	brandCacheTTL      = 10 * time.Minute
+	brandListCacheTTL  = 5 * time.Minute
+	brandListStaleFor  = time.Minute
 )
 
 type BrandResource struct {
-	store store.Store
-	cache *lru.TwoQueueCache
+	store      store.Store
+	cache      cache.Backend
+	brands     *cache.Namespace[*models.Brand]
+	brandLists *cache.Namespace[*models.Page[*models.Brand]]
 }
 
-func NewBrandResources(store store.Store, cache *lru.TwoQueueCache) *BrandResource {
+func NewBrandResources(store store.Store, backend cache.Backend) *BrandResource {
 	return &BrandResource{
 		store: store,
-		cache: cache,
+		cache: backend,
+		brands: cache.NewNamespace[*models.Brand](backend, brandNamespace, brandCacheSize,
+			cache.WithTTL(brandCacheTTL, cacheTTLJitter)),
+		brandLists: cache.NewNamespace[*models.Page[*models.Brand]](backend, brandListsNamespace, brandListCacheSize,
+			cache.WithTTL(brandListCacheTTL, cacheTTLJitter), cache.WithStaleWhileRevalidate(brandListStaleFor)),
 	}
 }
 
@@ -33,7 +48,7 @@ func (br *BrandResource) Routes(auth func(handler http.Handler) http.Handler) ch
 	r.Get("/{id}", br.ByID)
 
 	r.Group(func(r chi.Router) {
-		r.Use(auth)
+		r.Use(auth, RequirePermission(models.PermBrandsWrite))
 		r.Post("/", br.CreateBrand)
 		r.Put("/", br.UpdateBrand)
 		r.Delete("/{id}", br.DeleteBrand)
@@ -43,24 +58,18 @@ func (br *BrandResource) Routes(auth func(handler http.Handler) http.Handler) ch
 }
 
 func (br *BrandResource) CreateBrand(w http.ResponseWriter, r *http.Request) {
-	if !pkg.IsUserAdmin(r.Context(), w) {
-		return
-	}
-
 	brand := new(models.Brand)
 	if err := json.NewDecoder(r.Body).Decode(brand); err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
 		return
 	}
 
 	if err := br.store.Brands().Create(r.Context(), brand); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error : %v", err)
+		storeError(w, r, err)
 		return
 	}
 
-	br.cache.Purge() // чистка кэша после создания бренда
+	br.cache.Invalidate(brandListsTag)
 
 	w.WriteHeader(http.StatusCreated)
 }
@@ -69,62 +78,51 @@ func (br *BrandResource) AllBrands(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	filter := &models.BrandFilter{}
 
+	var err error
+	if filter.Limit, filter.After, err = pageParams(queryValues); err != nil {
+		pageError(w, r, err)
+		return
+	}
+
 	searchQuery := queryValues.Get("query")
 	if searchQuery != "" {
-		brandsFromCache, ok := br.cache.Get(searchQuery)
-		if ok {
-			render.JSON(w, r, brandsFromCache)
-			return
-		}
 		filter.Query = &searchQuery
 	}
 
-	brands, err := br.store.Brands().All(r.Context(), filter)
+	cacheKey := pageCacheKey("query="+searchQuery, filter.Limit, queryValues.Get("cursor"))
+	brands, err := br.brandLists.GetOrLoad(r.Context(), cacheKey, []string{brandListsTag},
+		func(ctx context.Context) (*models.Page[*models.Brand], error) {
+			return br.store.Brands().All(ctx, filter)
+		})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB err: %v", err)
+		storeError(w, r, err)
 		return
 	}
-	if searchQuery != "" {
-		br.cache.Add(searchQuery, brands)
-	}
 	render.JSON(w, r, brands)
 }
 func (br *BrandResource) ByID(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown err: %v", err)
-		return
-	}
-
-	brandFromCache, ok := br.cache.Get(id)
-	if ok {
-		render.JSON(w, r, brandFromCache)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
 		return
 	}
 
-	brand, err := br.store.Brands().ByID(r.Context(), id)
+	brand, err := br.brands.GetOrLoad(r.Context(), idStr, []string{brandTag(id)},
+		func(ctx context.Context) (*models.Brand, error) {
+			return br.store.Brands().ByID(ctx, id)
+		})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB err: %v", err)
+		storeError(w, r, err)
 		return
 	}
-
-	br.cache.Add(id, brand)
 	render.JSON(w, r, brand)
 }
 
 func (br *BrandResource) UpdateBrand(w http.ResponseWriter, r *http.Request) {
-	if !pkg.IsUserAdmin(r.Context(), w) {
-		return
-	}
-
 	brand := new(models.Brand)
 	if err := json.NewDecoder(r.Body).Decode(brand); err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
 		return
 	}
 	err := validation.ValidateStruct(
@@ -132,37 +130,34 @@ func (br *BrandResource) UpdateBrand(w http.ResponseWriter, r *http.Request) {
 		validation.Field(&brand.ID, validation.Required),
 		validation.Field(&brand.Name, validation.Required))
 	if err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		fmt.Fprintf(w, "Unknown err : %v", err)
+		apierror.Validation(w, r, err)
 		return
 	}
 
 	if err := br.store.Brands().Update(r.Context(), brand); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+		storeError(w, r, err)
 		return
 	}
 
-	br.cache.Remove(brand.ID)
+	br.invalidateBrand(brand.ID)
 }
 
 func (br *BrandResource) DeleteBrand(w http.ResponseWriter, r *http.Request) {
-	if !pkg.IsUserAdmin(r.Context(), w) {
-		return
-	}
-
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
 		return
 	}
 	if err := br.store.Brands().Delete(r.Context(), id); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error %v", err)
+		storeError(w, r, err)
 		return
 	}
 
-	br.cache.Remove(id)
+	br.invalidateBrand(id)
+}
+
+// invalidateBrand убирает бренд и списки брендов, в которых могло остаться старое название
+func (br *BrandResource) invalidateBrand(id int) {
+	br.cache.Invalidate(brandTags(id)...)
 }
@@ -0,0 +1,72 @@
+package resources
+
+import (
+	"fmt"
+	"project/internal/cache"
+	"project/internal/store"
+	"strings"
+)
+
+// Пространства имен кэша ресурсов
+const (
+	carNamespace        = "car"
+	carListsNamespace   = "cars"
+	brandNamespace      = "brand"
+	brandListsNamespace = "brands"
+)
+
+// Теги записей кэша: запись помечается тем, от чего зависит, а запись в базу
+// сбрасывает только записи со своими тегами
+const (
+	// carListsTag списки машин, в которые может попасть любое объявление: поиск и сортировки
+	carListsTag = "cars:list"
+	// brandListsTag списки брендов
+	brandListsTag = "brands:list"
+
+	// cacheTTLJitter доля, на которую случайно отклоняется TTL записей
+	cacheTTLJitter = 0.1
+)
+
+func carTag(id int) string {
+	return fmt.Sprintf("car:%d", id)
+}
+
+func brandTag(id int) string {
+	return fmt.Sprintf("brand:%d", id)
+}
+
+// cityTag город без учета регистра, как его сравнивает фильтр
+func cityTag(city string) string {
+	return "city:" + strings.ToLower(strings.TrimSpace(city))
+}
+
+// carTags теги записей, которые устаревают при изменении объявления в городах cities
+func carTags(id int, cities ...string) []string {
+	tags := []string{carTag(id), carListsTag}
+	for _, city := range cities {
+		tags = append(tags, cityTag(city))
+	}
+	return tags
+}
+
+func brandTags(id int) []string {
+	return []string{brandTag(id), brandListsTag}
+}
+
+// ChangeTags теги записей, устаревших после изменения в хранилище, например сделанного другой репликой
+func ChangeTags(change store.Change) []string {
+	switch change.Entity {
+	case store.ChangeCar:
+		return carTags(change.ID, change.Cities...)
+	case store.ChangeBrand:
+		return brandTags(change.ID)
+	}
+	return nil
+}
+
+// PurgeCache очищает все пространства имен ресурсов, когда неизвестно, что именно устарело
+func PurgeCache(backend cache.Backend) {
+	for _, name := range []string{carNamespace, carListsNamespace, brandNamespace, brandListsNamespace} {
+		backend.Bucket(name, 0).Purge()
+	}
+}
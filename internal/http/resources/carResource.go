@@ -1,48 +1,75 @@
 package resources
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	validation "github.com/go-ozzo/ozzo-validation"
-	lru "github.com/hashicorp/golang-lru"
 	"net/http"
+	"net/url"
+	"project/internal/cache"
 	"project/internal/models"
 	"project/internal/pkg"
+	"project/internal/pkg/apierror"
+	"project/internal/pkg/policy"
 	"project/internal/store"
 	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	carCacheSize     = 512
+	carListCacheSize = 256
+	carCacheTTL      = 5 * time.Minute
+	carListCacheTTL  = time.Minute
+	// carListStaleFor сколько после истечения TTL список еще отдается, пока обновляется в фоне
+	carListStaleFor = 30 * time.Second
 )
 
 type CarResource struct {
-	store store.Store
-	cache *lru.TwoQueueCache
+	store    store.Store
+	cache    cache.Backend
+	cars     *cache.Namespace[*models.Car]
+	carLists *cache.Namespace[*models.Page[*models.Car]]
 }
 
-func NewCarResource(store store.Store, cache *lru.TwoQueueCache) *CarResource {
+func NewCarResource(store store.Store, backend cache.Backend) *CarResource {
 	return &CarResource{
 		store: store,
-		cache: cache,
+		cache: backend,
+		cars: cache.NewNamespace[*models.Car](backend, carNamespace, carCacheSize,
+			cache.WithTTL(carCacheTTL, cacheTTLJitter)),
+		carLists: cache.NewNamespace[*models.Page[*models.Car]](backend, carListsNamespace, carListCacheSize,
+			cache.WithTTL(carListCacheTTL, cacheTTLJitter), cache.WithStaleWhileRevalidate(carListStaleFor)),
 	}
 }
 
 func (cr *CarResource) Routes(auth func(handler http.Handler) http.Handler) chi.Router {
 	r := chi.NewRouter()
 
+	r.Get("/", cr.AllCars)
 	r.Get("/all", cr.AllCars)
-	r.Get("/{id}", cr.ByID)
+	r.Get("/{id:[0-9]+}", cr.ByID)
 	r.Get("/{city}", cr.FilterCarsByCity)
 	r.Get("/sort_by={sortType}", cr.SortCars)
-	r.Post("/favourites", cr.AddToFavourites)
-	r.Delete("/favourites", cr.DeleteFromFavourites)
-	r.Get("/favourites", cr.ShowFavourites)
 
 	r.Group(func(r chi.Router) {
 		r.Use(auth)
-		r.Post("/", cr.CreateCar)
-		r.Put("/", cr.UpdateCar)
-		r.Delete("/{id}", cr.DeleteCar)
-		r.Get("/", cr.AllUserCars)
+		r.Post("/favourites", cr.AddToFavourites)
+		r.Delete("/favourites", cr.DeleteFromFavourites)
+		r.Get("/favourites", cr.ShowFavourites)
+		r.Get("/my", cr.AllUserCars)
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(models.PermCarsWrite))
+			r.Post("/", cr.CreateCar)
+			r.Put("/", cr.UpdateCar)
+			r.Put("/{id}", cr.UpdateCar)
+			r.Patch("/{id}", cr.PatchCar)
+			r.Delete("/{id}", cr.DeleteCar)
+		})
 	})
 
 	return r
@@ -51,48 +78,43 @@ func (cr *CarResource) Routes(auth func(handler http.Handler) http.Handler) chi.
 func (cr *CarResource) CreateCar(w http.ResponseWriter, r *http.Request) {
 	car := new(models.Car)
 	if err := json.NewDecoder(r.Body).Decode(car); err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
 		return
 	}
 
 	car.UserId = r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo).Id
 
+	user, err := cr.store.Users().ByID(r.Context(), car.UserId)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !user.Verified() {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeEmailNotVerified, "Confirm your email before posting cars", nil)
+		return
+	}
+
 	if err := cr.store.Cars().Create(r.Context(), car); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error : %v", err)
+		storeError(w, r, err)
 		return
 	}
 
-	cr.cache.Purge() // чистка кэша после добавление нового объявления
+	cr.cache.Invalidate(carListsTag, cityTag(car.City))
 
 	w.WriteHeader(http.StatusCreated)
 }
 
 func (cr *CarResource) AllCars(w http.ResponseWriter, r *http.Request) {
-	queryValues := r.URL.Query()
-	filter := &models.CarFilter{}
-
-	searchQuery := queryValues.Get("query")
-	if searchQuery != "" {
-		carsFromCache, ok := cr.cache.Get(searchQuery)
-		if ok {
-			render.JSON(w, r, carsFromCache)
-			return
-		}
-		filter.Query = &searchQuery
-	}
-
-	cars, err := cr.store.Cars().All(r.Context(), filter)
+	filter, err := carFilterFromQuery(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, err.Error(), nil)
 		return
 	}
-	if searchQuery != "" {
-		cr.cache.Add(searchQuery, cars)
-	}
-	render.JSON(w, r, cars)
+
+	searchParams := r.URL.Query()
+	searchParams.Del("limit")
+	searchParams.Del("cursor")
+	cr.searchCars(w, r, "search?"+searchParams.Encode(), filter, carListsTag)
 }
 
 func (cr *CarResource) AllUserCars(w http.ResponseWriter, r *http.Request) {
@@ -100,8 +122,7 @@ func (cr *CarResource) AllUserCars(w http.ResponseWriter, r *http.Request) {
 
 	cars, err := cr.store.Cars().AllOfUser(r.Context(), userInfo.Id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB err: %v", err)
+		storeError(w, r, err)
 		return
 	}
 	render.JSON(w, r, cars)
@@ -111,156 +132,263 @@ func (cr *CarResource) ByID(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown err: %v", err)
-		return
-	}
-
-	carFromCache, ok := cr.cache.Get(id)
-	if ok {
-		render.JSON(w, r, carFromCache)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
 		return
 	}
 
-	car, err := cr.store.Cars().ByID(r.Context(), id)
+	car, err := cr.cars.GetOrLoad(r.Context(), idStr, []string{carTag(id)},
+		func(ctx context.Context) (*models.Car, error) {
+			return cr.store.Cars().ByID(ctx, id)
+		})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB err: %v", err)
+		storeError(w, r, err)
 		return
 	}
-
-	cr.cache.Add(id, car)
 	render.JSON(w, r, car)
 }
 
+// UpdateCar заменяет объявление целиком. id берется из пути, для PUT / - из тела запроса
 func (cr *CarResource) UpdateCar(w http.ResponseWriter, r *http.Request) {
 	car := new(models.Car)
 	if err := json.NewDecoder(r.Body).Decode(car); err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
 		return
 	}
+	if idStr := chi.URLParam(r, "id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+			return
+		}
+		car.ID = id
+	}
 	err := validation.ValidateStruct(
 		car,
 		validation.Field(&car.ID, validation.Required),
 	)
 	if err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		fmt.Fprintf(w, "Unknown err : %v", err)
+		apierror.Validation(w, r, err)
+		return
+	}
+
+	stored, ok := cr.carFor(w, r, policy.Update, car.ID)
+	if !ok {
+		return
+	}
+	car.UserId = stored.UserId
+
+	cr.saveCar(w, r, car, stored.City)
+}
+
+// PatchCar меняет только переданные в теле поля объявления
+func (cr *CarResource) PatchCar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+		return
+	}
+
+	patch := new(models.CarPatch)
+	if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+
+	car, ok := cr.carFor(w, r, policy.Update, id)
+	if !ok {
+		return
+	}
+	oldCity := car.City
+	patch.Apply(car)
+
+	cr.saveCar(w, r, car, oldCity)
+}
+
+// carFor загружает объявление и проверяет, что текущий пользователь может выполнить над ним действие
+func (cr *CarResource) carFor(w http.ResponseWriter, r *http.Request, action policy.Action, id int) (*models.Car, bool) {
+	car, err := cr.store.Cars().ByID(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
+		return nil, false
+	}
+	if !authorize(w, r, action, car) {
+		return nil, false
+	}
+	return car, true
+}
+
+// saveCar сохраняет объявление. oldCity нужен, чтобы сбросить и список города, из которого машина ушла
+func (cr *CarResource) saveCar(w http.ResponseWriter, r *http.Request, car *models.Car, oldCity string) {
+	if err := car.Validate(); err != nil {
+		apierror.Validation(w, r, err)
 		return
 	}
 
 	if err := cr.store.Cars().Update(r.Context(), car); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+		storeError(w, r, err)
 		return
 	}
 
-	cr.cache.Remove(car.ID)
+	cr.invalidateCar(car.ID, oldCity, car.City)
+	render.JSON(w, r, car)
 }
 
 func (cr *CarResource) DeleteCar(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+		return
+	}
+	car, ok := cr.carFor(w, r, policy.Delete, id)
+	if !ok {
 		return
 	}
 	if err := cr.store.Cars().Delete(r.Context(), id); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error %v", err)
+		storeError(w, r, err)
 		return
 	}
 
-	cr.cache.Remove(id)
+	cr.invalidateCar(id, car.City)
+}
 
+// invalidateCar сбрасывает объявление, общие списки и списки городов cities:
+// после изменения полей машина может как выпасть из выборки, так и попасть в нее
+func (cr *CarResource) invalidateCar(id int, cities ...string) {
+	cr.cache.Invalidate(carTags(id, cities...)...)
 }
 
 func (cr *CarResource) SortCars(w http.ResponseWriter, r *http.Request) {
 	sortType := chi.URLParam(r, "sortType")
 
-	sortedCarsFromCache, ok := cr.cache.Get(sortType)
-	if ok {
-		render.JSON(w, r, sortedCarsFromCache)
-		return
-	}
-
-	sortedCars, err := cr.store.Cars().Sort(r.Context(), sortType)
+	keys, err := models.ParseCarSort(sortType)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, err.Error(), nil)
 		return
 	}
-
-	cr.cache.Add(sortType, sortedCars)
-	render.JSON(w, r, sortedCars)
+	cr.searchCars(w, r, "sort:"+sortType, &models.CarFilter{Sort: keys}, carListsTag)
 }
 
 func (cr *CarResource) FilterCarsByCity(w http.ResponseWriter, r *http.Request) {
-	filter := chi.URLParam(r, "city")
+	city := chi.URLParam(r, "city")
+	cr.searchCars(w, r, "city:"+city, &models.CarFilter{Cities: []string{city}}, cityTag(city))
+}
 
-	filteredCarsFromCache, ok := cr.cache.Get(filter)
-	if ok {
-		render.JSON(w, r, filteredCarsFromCache)
+// searchCars отдает страницу результата поиска. Непустой cacheKey кэширует каждую страницу отдельно
+// с тегами tags, по которым ее сбросят записи объявлений
+func (cr *CarResource) searchCars(w http.ResponseWriter, r *http.Request, cacheKey string, filter *models.CarFilter, tags ...string) {
+	var err error
+	if filter.Limit, filter.After, err = pageParams(r.URL.Query()); err != nil {
+		pageError(w, r, err)
 		return
 	}
 
-	filteredCars, err := cr.store.Cars().FilterByCity(r.Context(), filter)
+	load := func(ctx context.Context) (*models.Page[*models.Car], error) {
+		return cr.store.Cars().All(ctx, filter)
+	}
+	var cars *models.Page[*models.Car]
+	if cacheKey != "" {
+		cacheKey = pageCacheKey(cacheKey, filter.Limit, r.URL.Query().Get("cursor"))
+		cars, err = cr.carLists.GetOrLoad(r.Context(), cacheKey, tags, load)
+	} else {
+		cars, err = load(r.Context())
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+		storeError(w, r, err)
 		return
 	}
-
-	cr.cache.Add(filter, filteredCars)
-	render.JSON(w, r, filteredCars)
+	render.JSON(w, r, cars)
 }
 
 func (cr *CarResource) AddToFavourites(w http.ResponseWriter, r *http.Request) {
-	queryValues := r.URL.Query()
-	filter := &models.CarFilter{}
-	searchQuery, err := strconv.Atoi(queryValues.Get("id"))
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+	carId, err := strconv.Atoi(r.URL.Query().Get("id"))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown error: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+		return
 	}
 
-	filter.CarId = &searchQuery
-
-	if err := cr.store.Cars().AddToFav(r.Context(), filter); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+	if err := cr.store.Cars().AddToFav(r.Context(), userInfo.Id, carId); err != nil {
+		storeError(w, r, err)
 		return
 	}
-
 }
 
 func (cr *CarResource) DeleteFromFavourites(w http.ResponseWriter, r *http.Request) {
-	queryValues := r.URL.Query()
-	filter := &models.CarFilter{}
-	searchQuery, err := strconv.Atoi(queryValues.Get("id"))
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+	carId, err := strconv.Atoi(r.URL.Query().Get("id"))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown error: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+		return
 	}
 
-	filter.CarId = &searchQuery
-
-	if err := cr.store.Cars().DeleteFromFav(r.Context(), filter); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+	if err := cr.store.Cars().DeleteFromFav(r.Context(), userInfo.Id, carId); err != nil {
+		storeError(w, r, err)
 		return
 	}
 }
 
 func (cr *CarResource) ShowFavourites(w http.ResponseWriter, r *http.Request) {
-	favouriteCars, err := cr.store.Cars().ShowFav(r.Context())
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+
+	favouriteCars, err := cr.store.Cars().ShowFav(r.Context(), userInfo.Id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+		storeError(w, r, err)
 		return
 	}
 
 	render.JSON(w, r, favouriteCars)
 }
+
+// carFilterFromQuery читает параметры поиска. Списки передаются через запятую или повтором параметра
+func carFilterFromQuery(values url.Values) (*models.CarFilter, error) {
+	filter := &models.CarFilter{}
+
+	if query := values.Get("query"); query != "" {
+		filter.Query = &query
+	}
+	for _, brandID := range listParam(values, "brand_id") {
+		id, err := strconv.Atoi(brandID)
+		if err != nil {
+			return nil, fmt.Errorf("brand_id: %w", err)
+		}
+		filter.BrandIDs = append(filter.BrandIDs, id)
+	}
+	filter.Cities = listParam(values, "city")
+
+	for name, dst := range map[string]**int{
+		"year_from":  &filter.YearFrom,
+		"year_to":    &filter.YearTo,
+		"price_from": &filter.PriceFrom,
+		"price_to":   &filter.PriceTo,
+	} {
+		if values.Get(name) == "" {
+			continue
+		}
+		v, err := strconv.Atoi(values.Get(name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		*dst = &v
+	}
+
+	keys, err := models.ParseCarSort(values.Get("sort"))
+	if err != nil {
+		return nil, err
+	}
+	filter.Sort = keys
+
+	return filter, filter.Validate()
+}
+
+func listParam(values url.Values, name string) []string {
+	list := make([]string, 0)
+	for _, value := range values[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,152 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"project/internal/cache"
+	"project/internal/models"
+	"project/internal/pkg"
+	"project/internal/store"
+	"project/internal/store/inmemory"
+	"strings"
+	"testing"
+)
+
+// pausedStore задерживает следующий список машин, пока тест не отпустит его
+type pausedStore struct {
+	store.Store
+	cars *pausedCars
+}
+
+func (s *pausedStore) Cars() store.CarsRepository {
+	return s.cars
+}
+
+type pausedCars struct {
+	store.CarsRepository
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *pausedCars) All(ctx context.Context, filter *models.CarFilter) (*models.Page[*models.Car], error) {
+	page, err := c.CarsRepository.All(ctx, filter)
+	if c.started != nil {
+		started := c.started
+		c.started = nil
+		close(started)
+		<-c.release
+	}
+	return page, err
+}
+
+func asUser(info *models.AuthorizedInfo) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pkg.CtxKeyUser, info)))
+		})
+	}
+}
+
+func serve(t *testing.T, h http.Handler, method, path, body string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if rec.Code >= 300 {
+		t.Fatalf("%s %s = %d %s", method, path, rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+func carModels(t *testing.T, body string) []string {
+	t.Helper()
+	page := new(models.Page[*models.Car])
+	if err := json.Unmarshal([]byte(body), page); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(page.Items))
+	for _, car := range page.Items {
+		names = append(names, car.Model)
+	}
+	return names
+}
+
+// TestCarListNotStaleAfterConcurrentUpdate список читается из базы до изменения машины, а в кэш
+// попадает уже после него: следующий запрос все равно должен увидеть изменение
+func TestCarListNotStaleAfterConcurrentUpdate(t *testing.T) {
+	ctx := context.Background()
+	db := inmemory.NewDB()
+	if err := db.Users().Create(ctx, &models.User{Email: "dealer@kolesa.kz", Password: "secret1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Brands().Create(ctx, &models.Brand{Name: "Lada"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Cars().Create(ctx, &models.Car{Model: "Niva", UserId: 1, BrandID: 1, City: "Almaty", Year: 2010, Price: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	cars := &pausedCars{CarsRepository: db.Cars()}
+	resource := NewCarResource(&pausedStore{Store: db, cars: cars}, cache.NewLRU())
+	h := resource.Routes(asUser(&models.AuthorizedInfo{Id: 1, Role: models.Client, Permissions: models.Client.Permissions()}))
+
+	for _, path := range []string{"/Almaty", "/?query=Niva"} {
+		t.Run(path, func(t *testing.T) {
+			started, release := make(chan struct{}), make(chan struct{})
+			cars.started, cars.release = started, release
+			done := make(chan string)
+			go func() {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+				done <- rec.Body.String()
+			}()
+
+			<-started
+			serve(t, h, "PATCH", "/1", `{"model":"Vesta","city":"Astana"}`)
+			close(release)
+			if got := carModels(t, <-done); len(got) != 1 || got[0] != "Niva" {
+				t.Fatalf("list loaded before the update = %v", got)
+			}
+
+			if got := carModels(t, serve(t, h, "GET", path, "")); len(got) != 0 {
+				t.Errorf("stale list %v survived the update", got)
+			}
+			serve(t, h, "PATCH", "/1", `{"model":"Niva","city":"Almaty"}`)
+		})
+	}
+}
+
+// TestCarListsResetByMove машина переезжает в другой город: закэшированные списки обоих городов
+// и поиска должны это отразить
+func TestCarListsResetByMove(t *testing.T) {
+	ctx := context.Background()
+	db := inmemory.NewDB()
+	if err := db.Users().Create(ctx, &models.User{Email: "dealer@kolesa.kz", Password: "secret1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Brands().Create(ctx, &models.Brand{Name: "Lada"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Cars().Create(ctx, &models.Car{Model: "Niva", UserId: 1, BrandID: 1, City: "Almaty", Year: 2010, Price: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	resource := NewCarResource(db, cache.NewLRU())
+	h := resource.Routes(asUser(&models.AuthorizedInfo{Id: 1, Role: models.Client, Permissions: models.Client.Permissions()}))
+
+	for _, path := range []string{"/Almaty", "/Astana", "/?query=Niva"} {
+		serve(t, h, "GET", path, "")
+	}
+	serve(t, h, "PATCH", "/1", `{"city":"Astana"}`)
+
+	if got := carModels(t, serve(t, h, "GET", "/Almaty", "")); len(got) != 0 {
+		t.Errorf("old city still lists %v", got)
+	}
+	if got := carModels(t, serve(t, h, "GET", "/Astana", "")); len(got) != 1 {
+		t.Errorf("new city lists %v, want the moved car", got)
+	}
+	if got := carModels(t, serve(t, h, "GET", "/?query=Niva", "")); len(got) != 1 {
+		t.Errorf("search lists %v, want the moved car", got)
+	}
+}
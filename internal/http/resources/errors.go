@@ -0,0 +1,46 @@
+package resources
+
+import (
+	"errors"
+	"github.com/go-chi/chi/middleware"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"log"
+	"math"
+	"net/http"
+	"project/internal/models"
+	"project/internal/pkg/apierror"
+	"project/internal/store"
+	"strconv"
+	"time"
+)
+
+// storeError отвечает статусом, соответствующим ошибке хранилища.
+// Текст ошибок базы данных клиенту не отдается, только пишется в лог
+func storeError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErrs validation.Errors
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "Not found", nil)
+	case errors.Is(err, store.ErrConflict):
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict, "Already exists", nil)
+	case errors.Is(err, store.ErrInvalidReference):
+		apierror.Write(w, r, http.StatusUnprocessableEntity, apierror.CodeInvalidReference, "Referenced resource does not exist or is still in use", nil)
+	case errors.Is(err, models.ErrInvalidCursor):
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidCursor, "Invalid cursor", nil)
+	case errors.As(err, &validationErrs):
+		apierror.Validation(w, r, validationErrs)
+	default:
+		log.Printf("[store] request %s: %v", middleware.GetReqID(r.Context()), err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+	}
+}
+
+// tooManyRequests отвечает 429 с заголовком Retry-After в секундах
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	apierror.Write(w, r, http.StatusTooManyRequests, apierror.CodeTooManyRequests, message, nil)
+}
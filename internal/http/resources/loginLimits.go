@@ -0,0 +1,117 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"project/internal/models"
+	"project/internal/pkg"
+	"project/internal/pkg/apierror"
+	"project/internal/pkg/limiter"
+	"strings"
+	"time"
+)
+
+// loginLimit счетчик неудачных входов по одному ключу
+type loginLimit struct {
+	limiter limiter.Limiter
+	key     string
+}
+
+// WithLoginLimiters задает счетчики неудачных входов по адресу клиента и по email
+func WithLoginLimiters(byIP, byEmail limiter.Limiter) AuthOption {
+	return func(a *AuthResource) {
+		a.ipLimiter = byIP
+		a.emailLimiter = byEmail
+	}
+}
+
+func (a *AuthResource) loginLimits(ip, email string) []loginLimit {
+	return []loginLimit{
+		{limiter: a.ipLimiter, key: "ip:" + ip},
+		{limiter: a.emailLimiter, key: emailLimitKey(email)},
+	}
+}
+
+func emailLimitKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLogin возвращает наибольшую задержку среди счетчиков попытки
+func (a *AuthResource) checkLogin(ctx context.Context, ip, email string) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, limit := range a.loginLimits(ip, email) {
+		status, err := limit.limiter.Check(ctx, limit.key)
+		if err != nil {
+			return 0, err
+		}
+		a.auditLimit(ctx, status, limit.key, ip, nil)
+		if status.RetryAfter > retryAfter {
+			retryAfter = status.RetryAfter
+		}
+	}
+	return retryAfter, nil
+}
+
+func (a *AuthResource) loginFailed(ctx context.Context, ip, email string) {
+	for _, limit := range a.loginLimits(ip, email) {
+		status, err := limit.limiter.Fail(ctx, limit.key)
+		if err != nil {
+			log.Printf("[auth] count failed login for %s: %v", limit.key, err)
+			continue
+		}
+		a.auditLimit(ctx, status, limit.key, ip, nil)
+	}
+}
+
+// loginSucceeded сбрасывает только счетчик email: успешный вход в свой аккаунт
+// не должен обнулять перебор с того же адреса
+func (a *AuthResource) loginSucceeded(ctx context.Context, ip, email string) {
+	key := emailLimitKey(email)
+	status, err := a.emailLimiter.Reset(ctx, key)
+	if err != nil {
+		log.Printf("[auth] reset login counter for %s: %v", key, err)
+		return
+	}
+	a.auditLimit(ctx, status, key, ip, nil)
+}
+
+func (a *AuthResource) auditLimit(ctx context.Context, status limiter.Status, key, ip string, actorID *int) {
+	if status.Event == limiter.NoEvent {
+		return
+	}
+	log.Printf("[auth] %s %s from %s", status.Event, key, ip)
+	err := a.store.Audit().Record(ctx, &models.AuditEvent{
+		Event:   string(status.Event),
+		Subject: key,
+		IP:      ip,
+		ActorID: actorID,
+	})
+	if err != nil {
+		log.Printf("[auth] audit %s %s: %v", status.Event, key, err)
+	}
+}
+
+// UnlockLogin снимает блокировку входа с аккаунта до истечения ее срока
+func (a *AuthResource) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	body := new(models.ForgotPasswordDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+	if err := body.Validate(); err != nil {
+		apierror.Validation(w, r, err)
+		return
+	}
+
+	key := emailLimitKey(body.Email)
+	status, err := a.emailLimiter.Reset(r.Context(), key)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+	a.auditLimit(r.Context(), status, key, clientIP(r), &userInfo.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
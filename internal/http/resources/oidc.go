@@ -0,0 +1,208 @@
+package resources
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/go-chi/render"
+	"log"
+	"net/http"
+	"project/internal/models"
+	"project/internal/pkg/apierror"
+	"project/internal/pkg/auth"
+	"project/internal/pkg/oidc"
+	"project/internal/store"
+	"time"
+)
+
+const (
+	oidcStateTTL     = 10 * time.Minute
+	oidcStatePurpose = "oidc-state"
+	oidcStateCookie  = "oidc_state"
+	oidcCookiePath   = "/auth/oidc"
+)
+
+var (
+	errUnverifiedEmail   = errors.New("identity provider has not verified the email")
+	errUnverifiedAccount = errors.New("local account with the email is not verified")
+)
+
+// oidcState параметры начатого входа, хранятся в подписанной cookie браузера до callback
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// WithOIDC включает вход через внешнего OIDC провайдера
+func WithOIDC(provider *oidc.Provider) AuthOption {
+	return func(a *AuthResource) {
+		a.oidc = provider
+	}
+}
+
+// OIDCLogin отправляет браузер к провайдеру. state, nonce и PKCE verifier запоминаются в cookie
+func (a *AuthResource) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var state oidcState
+	var err error
+	for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		if *value, err = oidc.RandomString(); err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+	if a.challenges == nil {
+		log.Printf("[auth] oidc login: no state signing key")
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+	signed, err := a.challenges.Sign(oidcStatePurpose, &state, oidcStateTTL)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    signed,
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, a.oidc.AuthCodeURL(state.State, state.Nonce, oidc.S256Challenge(state.Verifier)), http.StatusFound)
+}
+
+// OIDCCallback принимает code от провайдера и выдает наши токены так же, как LoginUser
+func (a *AuthResource) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeOIDCFailed,
+			fmt.Sprintf("Identity provider returned %s", providerErr), nil)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	state := new(oidcState)
+	if err != nil || a.challenges == nil || a.challenges.Verify(oidcStatePurpose, cookie.Value, state) != nil ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(query.Get("state"))) != 1 {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidOIDCState, "Invalid or expired login state, start again", nil)
+		return
+	}
+	// state одноразовый: повторный callback с той же cookie не пройдет
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcCookiePath, MaxAge: -1, HttpOnly: true})
+
+	claims, err := a.oidc.Exchange(r.Context(), query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		log.Printf("[auth] oidc exchange: %v", err)
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeOIDCFailed, "Could not sign in with the identity provider", nil)
+		return
+	}
+
+	user, err := a.oidcUser(r.Context(), claims, clientIP(r))
+	if errors.Is(err, errUnverifiedEmail) {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeEmailNotVerified, "The identity provider has not verified your email", nil)
+		return
+	}
+	if errors.Is(err, errUnverifiedAccount) {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeEmailNotVerified,
+			"An account with this email exists but is not verified, log in with its password and verify the email first", nil)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	// внешний вход заменяет только пароль, второй фактор по-прежнему нужен
+	step, err := a.twoFactorStep(r.Context(), user)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if step != "" {
+		a.loginChallenge(w, r, user, step)
+		return
+	}
+
+	tokens, err := a.CreateSession(r.Context(), &models.AuthorizedInfo{
+		Id:   user.ID,
+		Role: user.GetRole(),
+	}, r.UserAgent(), clientIP(r))
+	if err != nil {
+		log.Printf("[auth] create session: %v", err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+	render.JSON(w, r, tokens)
+}
+
+// oidcUser находит пользователя по привязанному внешнему аккаунту. Новый аккаунт привязывается
+// по подтвержденному провайдером email к подтвержденному пользователю, а если такого пользователя нет - он создается
+func (a *AuthResource) oidcUser(ctx context.Context, claims *oidc.Claims, ip string) (*models.User, error) {
+	identity, err := a.store.Identities().ByExternalID(ctx, a.oidc.Issuer(), claims.Subject)
+	if err == nil {
+		return a.store.Users().ByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err := a.store.Users().ByEmail(ctx, claims.Email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		user, err = a.createOIDCUser(ctx, claims)
+	case err == nil && !user.Verified():
+		// неподтвержденный адрес мог зарегистрировать кто угодно, привязка оставила бы ему доступ к аккаунту
+		return nil, errUnverifiedAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.store.Identities().Create(ctx, &models.Identity{
+		Issuer:  a.oidc.Issuer(),
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.audit(ctx, "oidc_linked", user.ID, ip)
+	return user, nil
+}
+
+// createOIDCUser пароль случайный: войти по паролю можно после сброса через /auth/password/forgot
+func (a *AuthResource) createOIDCUser(ctx context.Context, claims *oidc.Claims) (*models.User, error) {
+	password, err := auth.NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	name := claims.GivenName
+	if name == "" {
+		name = claims.Name
+	}
+	role := models.Client
+	user := &models.User{
+		Email:    claims.Email,
+		Password: password,
+		Name:     name,
+		Surname:  claims.FamilyName,
+		Role:     &role,
+	}
+	if err := a.store.Users().Create(ctx, user); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := a.store.Users().MarkVerified(ctx, user.ID, now); err != nil {
+		return nil, err
+	}
+	user.VerifiedAt = &now
+	return user, nil
+}
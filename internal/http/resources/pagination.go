@@ -0,0 +1,48 @@
+package resources
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"project/internal/models"
+	"project/internal/pkg/apierror"
+	"strconv"
+)
+
+// pageParams читает limit и cursor из query параметров
+func pageParams(values url.Values) (int, *models.Cursor, error) {
+	limit := models.DefaultPageLimit
+	if v := values.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return 0, nil, fmt.Errorf("limit must be a positive number")
+		}
+		if limit > models.MaxPageLimit {
+			limit = models.MaxPageLimit
+		}
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := models.DecodeCursor(v)
+		if err != nil {
+			return 0, nil, err
+		}
+		return limit, cursor, nil
+	}
+	return limit, nil, nil
+}
+
+// pageCacheKey ключ кэша для конкретной страницы выборки
+func pageCacheKey(prefix string, limit int, cursor string) string {
+	return fmt.Sprintf("%s|limit=%d|cursor=%s", prefix, limit, cursor)
+}
+
+// pageError отвечает 400 на неверные limit или cursor
+func pageError(w http.ResponseWriter, r *http.Request, err error) {
+	code := apierror.CodeBadRequest
+	if errors.Is(err, models.ErrInvalidCursor) {
+		code = apierror.CodeInvalidCursor
+	}
+	apierror.Write(w, r, http.StatusBadRequest, code, err.Error(), nil)
+}
@@ -0,0 +1,35 @@
+package resources
+
+import (
+	"fmt"
+	"net/http"
+	"project/internal/models"
+	"project/internal/pkg"
+	"project/internal/pkg/apierror"
+	"project/internal/pkg/policy"
+)
+
+// authorize проверяет права текущего пользователя на ресурс и отвечает 403, если их нет
+func authorize(w http.ResponseWriter, r *http.Request, action policy.Action, resource interface{}) bool {
+	userInfo, _ := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+	if err := policy.Authorize(userInfo, action, resource); err != nil {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, fmt.Sprintf("Insufficient rights to %s this resource", action), nil)
+		return false
+	}
+	return true
+}
+
+// RequirePermission пропускает запрос, только если в токене есть permission. Ставится после userIdentity
+func RequirePermission(permission models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userInfo, _ := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+			if userInfo == nil || !userInfo.Can(permission) {
+				apierror.Write(w, r, http.StatusForbidden, apierror.CodeInsufficientRights,
+					fmt.Sprintf("Permission %s is required", permission), nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
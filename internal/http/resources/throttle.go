@@ -0,0 +1,55 @@
+package resources
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle пропускает не больше limit запросов с одним ключом за window.
+// Счетчики живут в процессе, у каждой реплики свои
+type throttle struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*throttleWindow
+	swept   time.Time
+}
+
+type throttleWindow struct {
+	start time.Time
+	count int
+}
+
+func newThrottle(limit int, window time.Duration) *throttle {
+	return &throttle{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*throttleWindow),
+	}
+}
+
+// allow учитывает запрос. Если лимит исчерпан, возвращает, через сколько можно повторить
+func (t *throttle) allow(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.swept) >= t.window {
+		for k, w := range t.windows {
+			if now.Sub(w.start) >= t.window {
+				delete(t.windows, k)
+			}
+		}
+		t.swept = now
+	}
+
+	w, ok := t.windows[key]
+	if !ok || now.Sub(w.start) >= t.window {
+		w = &throttleWindow{start: now}
+		t.windows[key] = w
+	}
+	if w.count >= t.limit {
+		return w.start.Add(t.window).Sub(now)
+	}
+	w.count++
+	return 0
+}
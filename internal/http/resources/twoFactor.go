@@ -0,0 +1,419 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/render"
+	"log"
+	"net/http"
+	"project/internal/models"
+	"project/internal/pkg"
+	"project/internal/pkg/apierror"
+	"project/internal/pkg/auth"
+	"project/internal/pkg/totp"
+	"project/internal/store"
+	"time"
+)
+
+const (
+	twoFactorChallengeTTL = 5 * time.Minute
+	twoFactorPurpose      = "2fa-login"
+	recoveryCodesCount    = 10
+
+	// шаги входа, которые возвращаются в LoginChallenge
+	twoFactorStepCode   = "code"
+	twoFactorStepEnroll = "enroll"
+)
+
+// ErrTwoFactorRequired роль пользователя требует 2FA, а она не подключена
+var ErrTwoFactorRequired = errors.New("two-factor authentication required")
+
+// twoFactorChallenge данные challenge токена, выданного после проверки пароля
+type twoFactorChallenge struct {
+	UserID int    `json:"uid"`
+	Email  string `json:"email"`
+	Step   string `json:"step"`
+}
+
+// WithTwoFactor задает ключ подписи challenge токенов и имя сервиса в приложении-аутентификаторе
+func WithTwoFactor(signer *auth.LinkSigner, issuer string) AuthOption {
+	return func(a *AuthResource) {
+		a.challenges = signer
+		a.issuer = issuer
+	}
+}
+
+// twoFactorStep что нужно пользователю после пароля: код, подключение 2FA или ничего
+func (a *AuthResource) twoFactorStep(ctx context.Context, user *models.User) (string, error) {
+	twoFactor, err := a.store.TwoFactor().ByUser(ctx, user.ID)
+	switch {
+	case err == nil && twoFactor.Enabled():
+		return twoFactorStepCode, nil
+	case err != nil && !errors.Is(err, store.ErrNotFound):
+		return "", err
+	case user.GetRole().RequiresTwoFactor():
+		return twoFactorStepEnroll, nil
+	default:
+		return "", nil
+	}
+}
+
+func (a *AuthResource) loginChallenge(w http.ResponseWriter, r *http.Request, user *models.User, step string) {
+	if a.challenges == nil {
+		log.Printf("[auth] two-factor login for user %d: no challenge signing key", user.ID)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+	token, err := a.challenges.Sign(twoFactorPurpose, &twoFactorChallenge{UserID: user.ID, Email: user.Email, Step: step}, twoFactorChallengeTTL)
+	if err != nil {
+		log.Printf("[auth] sign login challenge: %v", err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+	render.JSON(w, r, &models.LoginChallenge{
+		ChallengeToken: token,
+		Step:           step,
+		ExpiresIn:      int(twoFactorChallengeTTL.Seconds()),
+	})
+}
+
+// verifyChallenge отвечает 401, если challenge токен поддельный, истек или выдан для другого шага
+func (a *AuthResource) verifyChallenge(w http.ResponseWriter, r *http.Request, token string, steps ...string) (*twoFactorChallenge, bool) {
+	challenge := new(twoFactorChallenge)
+	if a.challenges == nil || a.challenges.Verify(twoFactorPurpose, token, challenge) != nil {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidChallenge, "Invalid or expired challenge token, log in again", nil)
+		return nil, false
+	}
+	for _, step := range steps {
+		if challenge.Step == step {
+			return challenge, true
+		}
+	}
+	apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidChallenge, fmt.Sprintf("Challenge is for the %q step", challenge.Step), nil)
+	return nil, false
+}
+
+// challengeUser пользователь из challenge. Шаг пересчитывается заново: пока токен жил, 2FA могли
+// подключить или отключить, а email сменить, тогда токен больше не действует
+func (a *AuthResource) challengeUser(w http.ResponseWriter, r *http.Request, challenge *twoFactorChallenge) (*models.User, bool) {
+	user, err := a.store.Users().ByID(r.Context(), challenge.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidChallenge, "Invalid or expired challenge token, log in again", nil)
+		return nil, false
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return nil, false
+	}
+	step, err := a.twoFactorStep(r.Context(), user)
+	if err != nil {
+		storeError(w, r, err)
+		return nil, false
+	}
+	if step != challenge.Step || user.Email != challenge.Email {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidChallenge, "Invalid or expired challenge token, log in again", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+// LoginTwoFactor второй шаг входа. Для шага enroll код подтверждает секрет, выданный
+// LoginEnroll, и в ответе приходят коды восстановления
+func (a *AuthResource) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	body := new(models.TwoFactorLoginDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+	if err := body.Validate(); err != nil {
+		apierror.Validation(w, r, err)
+		return
+	}
+	challenge, ok := a.verifyChallenge(w, r, body.ChallengeToken, twoFactorStepCode, twoFactorStepEnroll)
+	if !ok {
+		return
+	}
+
+	ip := clientIP(r)
+	retryAfter, err := a.checkLogin(r.Context(), ip, challenge.Email)
+	if err != nil {
+		log.Printf("[auth] check login limits: %v", err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+	if retryAfter > 0 {
+		tooManyRequests(w, r, retryAfter, "Too many failed login attempts, try again later")
+		return
+	}
+
+	user, ok := a.challengeUser(w, r, challenge)
+	if !ok {
+		return
+	}
+
+	var (
+		valid         bool
+		recoveryCodes []string
+	)
+	switch {
+	case challenge.Step == twoFactorStepEnroll:
+		recoveryCodes, valid, err = a.enableTwoFactor(r.Context(), user.ID, body.Code)
+	case body.RecoveryCode != "":
+		valid, err = a.useRecoveryCode(r.Context(), user.ID, body.RecoveryCode, ip)
+	default:
+		valid, err = a.checkTwoFactorCode(r.Context(), user.ID, body.Code)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !valid {
+		a.loginFailed(r.Context(), ip, challenge.Email)
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidTwoFactor, "Invalid two-factor code", nil)
+		return
+	}
+	a.loginSucceeded(r.Context(), ip, challenge.Email)
+	if recoveryCodes != nil {
+		a.audit(r.Context(), "2fa_enabled", user.ID, ip)
+	}
+
+	tokens, err := a.CreateSession(r.Context(), &models.AuthorizedInfo{
+		Id:   user.ID,
+		Role: user.GetRole(),
+	}, r.UserAgent(), ip)
+	if err != nil {
+		log.Printf("[auth] create session: %v", err)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil)
+		return
+	}
+	render.JSON(w, r, &models.TwoFactorLogin{Tokens: tokens, RecoveryCodes: recoveryCodes})
+}
+
+// LoginEnroll выдает секрет пользователю, которому 2FA обязательна, до того как он сможет войти
+func (a *AuthResource) LoginEnroll(w http.ResponseWriter, r *http.Request) {
+	body := new(models.TwoFactorLoginDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+	challenge, ok := a.verifyChallenge(w, r, body.ChallengeToken, twoFactorStepEnroll)
+	if !ok {
+		return
+	}
+	user, ok := a.challengeUser(w, r, challenge)
+	if !ok {
+		return
+	}
+	a.beginTwoFactor(w, r, user.ID, user.Email)
+}
+
+// EnrollTwoFactor начинает подключение 2FA: новый секрет действует после подтверждения кодом
+func (a *AuthResource) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+
+	user, err := a.store.Users().ByID(r.Context(), userInfo.Id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	a.beginTwoFactor(w, r, user.ID, user.Email)
+}
+
+func (a *AuthResource) beginTwoFactor(w http.ResponseWriter, r *http.Request, userID int, email string) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	err = a.store.TwoFactor().Begin(r.Context(), &models.TwoFactor{UserID: userID, Secret: secret})
+	if errors.Is(err, store.ErrConflict) {
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	render.JSON(w, r, &models.TwoFactorEnrollment{Secret: secret, URI: totp.URI(a.issuer, email, secret)})
+}
+
+// ConfirmTwoFactor включает 2FA первым кодом из приложения и возвращает коды восстановления
+func (a *AuthResource) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+	body, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, valid, err := a.enableTwoFactor(r.Context(), userInfo.Id, body.Code)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !valid {
+		apierror.Write(w, r, http.StatusUnprocessableEntity, apierror.CodeInvalidTwoFactor, "Invalid two-factor code", nil)
+		return
+	}
+	a.audit(r.Context(), "2fa_enabled", userInfo.Id, clientIP(r))
+	render.JSON(w, r, &models.RecoveryCodes{Codes: codes})
+}
+
+// RegenerateRecoveryCodes заменяет все коды восстановления новыми
+func (a *AuthResource) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+	body, ok := decodeTwoFactorCode(w, r)
+	if !ok || !a.requireTwoFactorCode(w, r, userInfo.Id, body.Code) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = a.store.TwoFactor().ReplaceRecoveryCodes(r.Context(), userInfo.Id, hashes)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	render.JSON(w, r, &models.RecoveryCodes{Codes: codes})
+}
+
+// DisableTwoFactor отключает 2FA. Ролям, которым она обязательна, отключить нельзя
+func (a *AuthResource) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
+	if userInfo.Role.RequiresTwoFactor() {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeTwoFactorRequired,
+			fmt.Sprintf("Two-factor authentication is required for the %s role", userInfo.Role), nil)
+		return
+	}
+	body, ok := decodeTwoFactorCode(w, r)
+	if !ok || !a.requireTwoFactorCode(w, r, userInfo.Id, body.Code) {
+		return
+	}
+
+	if err := a.store.TwoFactor().Disable(r.Context(), userInfo.Id); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	a.audit(r.Context(), "2fa_disabled", userInfo.Id, clientIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeTwoFactorCode(w http.ResponseWriter, r *http.Request) (*models.TwoFactorCodeDTO, bool) {
+	body := new(models.TwoFactorCodeDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return nil, false
+	}
+	if err := body.Validate(); err != nil {
+		apierror.Validation(w, r, err)
+		return nil, false
+	}
+	return body, true
+}
+
+// requireTwoFactorCode отвечает 422, если код не подходит к включенной 2FA
+func (a *AuthResource) requireTwoFactorCode(w http.ResponseWriter, r *http.Request, userID int, code string) bool {
+	valid, err := a.checkTwoFactorCode(r.Context(), userID, code)
+	if errors.Is(err, store.ErrNotFound) {
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict, "Two-factor authentication is not enabled", nil)
+		return false
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return false
+	}
+	if !valid {
+		apierror.Write(w, r, http.StatusUnprocessableEntity, apierror.CodeInvalidTwoFactor, "Invalid two-factor code", nil)
+		return false
+	}
+	return true
+}
+
+// checkTwoFactorCode проверяет код включенной 2FA. Принятый код запоминается и второй раз не подходит
+func (a *AuthResource) checkTwoFactorCode(ctx context.Context, userID int, code string) (bool, error) {
+	twoFactor, err := a.store.TwoFactor().ByUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !twoFactor.Enabled() {
+		return false, store.ErrNotFound
+	}
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err = a.store.TwoFactor().UseStep(ctx, userID, step)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// enableTwoFactor подтверждает неподтвержденный секрет и возвращает новые коды восстановления
+func (a *AuthResource) enableTwoFactor(ctx context.Context, userID int, code string) ([]string, bool, error) {
+	twoFactor, err := a.store.TwoFactor().ByUser(ctx, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if twoFactor.Enabled() {
+		return nil, false, store.ErrConflict
+	}
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return nil, false, nil
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, false, err
+	}
+	err = a.store.TwoFactor().Enable(ctx, userID, step, time.Now(), hashes)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return codes, true, nil
+}
+
+func (a *AuthResource) useRecoveryCode(ctx context.Context, userID int, code, ip string) (bool, error) {
+	err := a.store.TwoFactor().UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(code), time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	a.audit(ctx, "recovery_code_used", userID, ip)
+	return true, nil
+}
+
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := auth.NewRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func (a *AuthResource) audit(ctx context.Context, event string, userID int, ip string) {
+	err := a.store.Audit().Record(ctx, &models.AuditEvent{
+		Event:   event,
+		Subject: fmt.Sprintf("user:%d", userID),
+		IP:      ip,
+		ActorID: &userID,
+	})
+	if err != nil {
+		log.Printf("[auth] audit %s for user %d: %v", event, userID, err)
+	}
+}
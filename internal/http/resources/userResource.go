@@ -2,26 +2,30 @@ package resources
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
-	lru "github.com/hashicorp/golang-lru"
+	"log"
 	"net/http"
+	"project/internal/cache"
 	"project/internal/models"
 	"project/internal/pkg"
+	"project/internal/pkg/apierror"
+	"project/internal/pkg/policy"
 	"project/internal/store"
 	"strconv"
 )
 
 type UserResource struct {
-	store store.Store
-	cache *lru.TwoQueueCache
+	store        store.Store
+	cache        cache.Backend
+	verification *EmailVerification
 }
 
-func NewUserResource(store store.Store, cache *lru.TwoQueueCache) *UserResource {
+func NewUserResource(store store.Store, cache cache.Backend, verification *EmailVerification) *UserResource {
 	return &UserResource{
-		store: store,
-		cache: cache,
+		store:        store,
+		cache:        cache,
+		verification: verification,
 	}
 }
 
@@ -31,9 +35,10 @@ func (ur *UserResource) Routes(auth func(handler http.Handler) http.Handler) chi
 	r.Post("/registration", ur.CreateUser)
 	r.Group(func(r chi.Router) {
 		r.Use(auth)
-		r.Get("/", ur.AllUsers)
-		r.Put("/", ur.UpdateUser)
+		r.With(RequirePermission(models.PermUsersRead)).Get("/", ur.AllUsers)
+		r.With(requireInteractive).Put("/", ur.UpdateUser)
 		r.Delete("/{id}", ur.DeleteUser)
+		r.With(RequirePermission(models.PermUsersManage)).Put("/{id}/role", ur.UpdateRole)
 	})
 	return r
 
@@ -42,35 +47,38 @@ func (ur *UserResource) Routes(auth func(handler http.Handler) http.Handler) chi
 func (ur *UserResource) CreateUser(w http.ResponseWriter, r *http.Request) {
 	user := new(models.User)
 	if err := json.NewDecoder(r.Body).Decode(user); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
 		return
 	}
 
-	if _, err := ur.store.Users().ByEmail(r.Context(), user.Email); err == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error. User already exists")
-		return
-	}
+	role := models.Client
+	user.Role = &role // роль при регистрации не выбирается, ее меняет администратор
 
 	err := ur.store.Users().Create(r.Context(), user)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, err.Error())
+		storeError(w, r, err)
 		return
 	}
+	if ur.verification != nil {
+		if err := ur.verification.Send(r.Context(), user); err != nil {
+			log.Printf("[users] send verification to user %d: %v", user.ID, err)
+		}
+	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
 func (ur *UserResource) AllUsers(w http.ResponseWriter, r *http.Request) {
-	if !pkg.IsUserAdmin(r.Context(), w) {
+	filter := &models.UserFilter{}
+	var err error
+	if filter.Limit, filter.After, err = pageParams(r.URL.Query()); err != nil {
+		pageError(w, r, err)
 		return
 	}
-	users, err := ur.store.Users().All(r.Context())
+
+	users, err := ur.store.Users().All(r.Context(), filter)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+		storeError(w, r, err)
 		return
 	}
 	render.JSON(w, r, users)
@@ -79,36 +87,85 @@ func (ur *UserResource) AllUsers(w http.ResponseWriter, r *http.Request) {
 func (ur *UserResource) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	user := new(models.User)
 	if err := json.NewDecoder(r.Body).Decode(user); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
 		return
 	}
 	if _, err := ur.store.Users().ByEmail(r.Context(), user.Email); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error. User doesn't exist")
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "User doesn't exist", nil)
 		return
 	}
 	userInfo := r.Context().Value(pkg.CtxKeyUser).(*models.AuthorizedInfo)
 	user.ID = userInfo.Id
+	user.Role = nil // роль меняется только через PUT /users/{id}/role
 
+	current, err := ur.store.Users().ByID(r.Context(), user.ID)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	// Update стирает пароль из user после шифрования
+	passwordChanged := user.Password != "" && !current.ComparePassword(user.Password)
 	if err := ur.store.Users().Update(r.Context(), user); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error: %v", err)
+		storeError(w, r, err)
 		return
 	}
+	if passwordChanged {
+		if err := revokeAllSessions(r.Context(), ur.store, user.ID); err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
 }
 
 func (ur *UserResource) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Unknown err: %v", err)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+		return
+	}
+	if !authorize(w, r, policy.Delete, &models.User{ID: id}) {
+		return
+	}
+	// сессии удаляются вместе с пользователем, поэтому их токены отзываются заранее
+	if err := revokeAllSessions(r.Context(), ur.store, id); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	cars, err := ur.store.Users().Delete(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
 		return
 	}
-	if err = ur.store.Users().Delete(r.Context(), id); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "DB error : %v", err)
+	// объявления удаляются вместе с пользователем и не должны остаться в кэше
+	tags := make([]string, 0, 3*len(cars))
+	for _, car := range cars {
+		tags = append(tags, carTags(car.ID, car.City)...)
+	}
+	ur.cache.Invalidate(tags...)
+}
+
+// UpdateRole меняет роль пользователя. Новые права попадут в токен при следующем обновлении
+func (ur *UserResource) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "id must be an integer", nil)
+		return
+	}
+	body := new(models.RoleDTO)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidBody, "Request body must be valid JSON", nil)
+		return
+	}
+	if !body.Role.Valid() {
+		apierror.Write(w, r, http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "Validation failed",
+			map[string]string{"role": "must be one of admin, moderator, dealer, client"})
+		return
+	}
+
+	if err := ur.store.Users().UpdateRole(r.Context(), id, body.Role); err != nil {
+		storeError(w, r, err)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
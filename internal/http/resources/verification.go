@@ -0,0 +1,86 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"project/internal/models"
+	"project/internal/pkg/auth"
+	"project/internal/pkg/mail"
+	"project/internal/store"
+	"time"
+)
+
+const (
+	verificationTTL            = 72 * time.Hour
+	verificationResendInterval = time.Minute
+	verifyEmailPurpose         = "verify-email"
+)
+
+// verificationLink данные подписанной ссылки. Email входит в подпись, поэтому после
+// смены адреса старая ссылка не подтвердит новый
+type verificationLink struct {
+	UserID int    `json:"uid"`
+	Email  string `json:"email"`
+}
+
+// EmailVerification отправляет и проверяет ссылки подтверждения email
+type EmailVerification struct {
+	store     store.Store
+	mailer    mail.Mailer
+	signer    *auth.LinkSigner
+	publicURL string
+}
+
+func NewEmailVerification(store store.Store, mailer mail.Mailer, signer *auth.LinkSigner, publicURL string) *EmailVerification {
+	return &EmailVerification{
+		store:     store,
+		mailer:    mailer,
+		signer:    signer,
+		publicURL: publicURL,
+	}
+}
+
+// Send отправляет письмо со ссылкой. Если письмо уже отправлялось недавно или email подтвержден,
+// возвращает store.ErrNotFound
+func (v *EmailVerification) Send(ctx context.Context, user *models.User) error {
+	now := time.Now()
+	if err := v.store.Users().MarkVerificationSent(ctx, user.ID, now, now.Add(-verificationResendInterval)); err != nil {
+		return err
+	}
+	token, err := v.signer.Sign(verifyEmailPurpose, &verificationLink{UserID: user.ID, Email: user.Email}, verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return v.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("Welcome! Open the link below to confirm your email, it is valid for %s:\n\n%s/auth/verify?token=%s\n",
+			verificationTTL, v.publicURL, url.QueryEscape(token)),
+	})
+}
+
+// Verify подтверждает email пользователя из ссылки
+func (v *EmailVerification) Verify(ctx context.Context, token string) error {
+	link := new(verificationLink)
+	if err := v.signer.Verify(verifyEmailPurpose, token, link); err != nil {
+		return err
+	}
+	user, err := v.store.Users().ByID(ctx, link.UserID)
+	if err != nil {
+		return err
+	}
+	if user.Email != link.Email {
+		return auth.ErrInvalidLink
+	}
+	return v.store.Users().MarkVerified(ctx, user.ID, time.Now())
+}
+
+// retryAfter через сколько можно отправить письмо повторно
+func (v *EmailVerification) retryAfter(user *models.User) time.Duration {
+	if user.VerificationSentAt == nil {
+		return 0
+	}
+	return time.Until(user.VerificationSentAt.Add(verificationResendInterval))
+}
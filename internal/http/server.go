@@ -3,21 +3,40 @@ package http
 import (
 	"context"
 	"github.com/go-chi/chi"
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/go-chi/chi/middleware"
 	"log"
 	"net/http"
+	"project/internal/cache"
 	"project/internal/http/resources"
+	"project/internal/pkg/apierror"
 	"project/internal/pkg/auth"
+	"project/internal/pkg/limiter"
+	"project/internal/pkg/mail"
+	"project/internal/pkg/oidc"
 	"project/internal/store"
 	"time"
 )
 
+const (
+	// totpIssuer имя сервиса, под которым аккаунт виден в приложении-аутентификаторе
+	totpIssuer = "Kolesa"
+	// challengeScope область ключа challenge токенов входа, отдельная от ссылок в письмах
+	challengeScope = "login-challenges"
+)
+
 type Server struct {
 	ctx          context.Context
 	idleConnsCH  chan struct{}
 	store        store.Store
-	cache        *lru.TwoQueueCache
+	cache        cache.Backend
 	tokenManager auth.TokenManager
+	mailer       mail.Mailer
+	publicURL    string
+	linkSigner   *auth.LinkSigner
+	ipLimiter    limiter.Limiter
+	emailLimiter limiter.Limiter
+	oidc         *oidc.Provider
+	changes      store.ChangeListener
 	Address      string
 }
 
@@ -29,23 +48,68 @@ func NewServer(ctx context.Context, opts ...ServerOption) *Server {
 	for _, opts := range opts {
 		opts(srv)
 	}
+	if srv.cache == nil {
+		srv.cache = cache.NewLRU()
+	}
+	if srv.linkSigner == nil {
+		log.Println("[HTTP] no link signing key, using a random one: email links and login challenges stop working after restart")
+		signer, err := auth.NewRandomLinkSigner()
+		if err != nil {
+			panic(err)
+		}
+		srv.linkSigner = signer
+	}
 
 	return srv
 }
 
+func (s *Server) mailerOrLog() mail.Mailer {
+	if s.mailer == nil {
+		return mail.LogMailer{}
+	}
+	return s.mailer
+}
+
 func (s *Server) basicHandler() chi.Router {
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "Not found", nil)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, apierror.CodeBadRequest, "Method not allowed", nil)
+	})
+
 	brandsResource := resources.NewBrandResources(s.store, s.cache)
 	r.Mount("/brands", brandsResource.Routes(s.userIdentity))
 
 	carsResource := resources.NewCarResource(s.store, s.cache)
 	r.Mount("/cars", carsResource.Routes(s.userIdentity))
 
-	usersResource := resources.NewUserResource(s.store, s.cache)
+	verification := resources.NewEmailVerification(s.store, s.mailerOrLog(), s.linkSigner, s.publicURL)
+
+	usersResource := resources.NewUserResource(s.store, s.cache, verification)
 	r.Mount("/users", usersResource.Routes(s.userIdentity))
 
-	authResource := resources.NewAuthResource(s.store, s.cache, s.tokenManager)
-	r.Mount("/auth", authResource.Routes())
+	authOpts := []resources.AuthOption{
+		resources.WithEmailVerification(verification),
+		resources.WithTwoFactor(s.linkSigner.Derive(challengeScope), totpIssuer),
+	}
+	if s.mailer != nil {
+		authOpts = append(authOpts, resources.WithMailer(s.mailer, s.publicURL))
+	}
+	if s.ipLimiter != nil && s.emailLimiter != nil {
+		authOpts = append(authOpts, resources.WithLoginLimiters(s.ipLimiter, s.emailLimiter))
+	}
+	if s.oidc != nil {
+		authOpts = append(authOpts, resources.WithOIDC(s.oidc))
+	}
+	authResource := resources.NewAuthResource(s.store, s.tokenManager, authOpts...)
+	r.Mount("/auth", authResource.Routes(s.userIdentity))
+	r.Get("/.well-known/jwks.json", authResource.JWKS)
+
+	apiKeysResource := resources.NewAPIKeyResource(s.store)
+	r.Mount("/api-keys", apiKeysResource.Routes(s.userIdentity))
 	return r
 }
 
@@ -58,11 +122,23 @@ func (s *Server) Run() error {
 		WriteTimeout: time.Second * 30,
 	}
 	go s.ListenCtxForGt(srv)
+	if s.changes != nil {
+		go s.changes.ListenChanges(s.ctx, s.applyChange, s.purgeCache)
+	}
 
 	log.Println("Server running on ", s.Address)
 	return srv.ListenAndServe()
 }
 
+func (s *Server) applyChange(change store.Change) {
+	s.cache.Invalidate(resources.ChangeTags(change)...)
+}
+
+// purgeCache вызывается после переподключения к каналу изменений: пропущенные изменения неизвестны
+func (s *Server) purgeCache() {
+	resources.PurgeCache(s.cache)
+}
+
 func (s *Server) ListenCtxForGt(srv *http.Server) {
 	<-s.ctx.Done() // блокируемся пока контекст приложения не отменен
 
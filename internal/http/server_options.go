@@ -1,8 +1,11 @@
 package http
 
 import (
-	lru "github.com/hashicorp/golang-lru"
+	"project/internal/cache"
 	"project/internal/pkg/auth"
+	"project/internal/pkg/limiter"
+	"project/internal/pkg/mail"
+	"project/internal/pkg/oidc"
 	"project/internal/store"
 )
 
@@ -20,7 +23,7 @@ func WithStore(store store.Store) ServerOption {
 	}
 }
 
-func WithCache(cache *lru.TwoQueueCache) ServerOption {
+func WithCache(cache cache.Backend) ServerOption {
 	return func(srv *Server) {
 		srv.cache = cache
 	}
@@ -31,3 +34,40 @@ func WithTokenManager(tokenManager auth.TokenManager) ServerOption {
 		srv.tokenManager = tokenManager
 	}
 }
+
+// WithMailer задает отправку писем и публичный адрес сервиса для ссылок в письмах
+func WithMailer(mailer mail.Mailer, publicURL string) ServerOption {
+	return func(srv *Server) {
+		srv.mailer = mailer
+		srv.publicURL = publicURL
+	}
+}
+
+// WithLinkSigner задает ключ подписи ссылок в письмах
+func WithLinkSigner(signer *auth.LinkSigner) ServerOption {
+	return func(srv *Server) {
+		srv.linkSigner = signer
+	}
+}
+
+// WithLoginLimiters задает счетчики неудачных входов, по умолчанию они хранятся в памяти процесса
+func WithLoginLimiters(byIP, byEmail limiter.Limiter) ServerOption {
+	return func(srv *Server) {
+		srv.ipLimiter = byIP
+		srv.emailLimiter = byEmail
+	}
+}
+
+// WithOIDC включает вход через внешнего OIDC провайдера
+func WithOIDC(provider *oidc.Provider) ServerOption {
+	return func(srv *Server) {
+		srv.oidc = provider
+	}
+}
+
+// WithChangeListener сбрасывает записи кэша, устаревшие из-за изменений других реплик
+func WithChangeListener(listener store.ChangeListener) ServerOption {
+	return func(srv *Server) {
+		srv.changes = listener
+	}
+}
@@ -0,0 +1,147 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"project/internal/models"
+	"project/internal/store"
+	"strconv"
+)
+
+const (
+	brandColumns = 2 // id, name
+	carColumns   = 7 // id, model, brand_id, city, year, price, description
+)
+
+type Options struct {
+	// DryRun только проверяет строки, ничего не записывая
+	DryRun bool
+	// Upsert сохраняет id из файла и перезаписывает существующие записи
+	Upsert bool
+	// UserID владелец импортируемых объявлений
+	UserID int
+}
+
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+type Report struct {
+	Imported int
+	Failed   []RowError
+}
+
+type Importer struct {
+	store store.Store
+	opts  Options
+	// brandIDs соответствие id бренда из файла и id в базе, нужно когда id не сохраняются
+	brandIDs map[int]int
+}
+
+func New(store store.Store, opts Options) *Importer {
+	return &Importer{
+		store:    store,
+		opts:     opts,
+		brandIDs: make(map[int]int),
+	}
+}
+
+func (im *Importer) Brands(ctx context.Context, r io.Reader) (*Report, error) {
+	return im.run(r, brandColumns, func(record []string) error {
+		id, err := strconv.Atoi(record[0])
+		if err != nil {
+			return fmt.Errorf("id: %w", err)
+		}
+		brand := &models.Brand{ID: id, Name: record[1]}
+		if err := brand.Validate(); err != nil {
+			return err
+		}
+
+		if im.opts.DryRun {
+			im.brandIDs[id] = id
+			return nil
+		}
+		if im.opts.Upsert {
+			return im.store.Brands().Upsert(ctx, brand)
+		}
+		if err := im.store.Brands().Create(ctx, brand); err != nil {
+			return err
+		}
+		im.brandIDs[id] = brand.ID
+		return nil
+	})
+}
+
+func (im *Importer) Cars(ctx context.Context, r io.Reader) (*Report, error) {
+	return im.run(r, carColumns, func(record []string) error {
+		ints := make([]int, 0, 4)
+		for _, i := range []int{0, 2, 4, 5} {
+			v, err := strconv.Atoi(record[i])
+			if err != nil {
+				return fmt.Errorf("column %d: %w", i+1, err)
+			}
+			ints = append(ints, v)
+		}
+		car := &models.Car{
+			ID:          ints[0],
+			UserId:      im.opts.UserID,
+			Model:       record[1],
+			BrandID:     ints[1],
+			City:        record[3],
+			Year:        ints[2],
+			Price:       ints[3],
+			Description: record[6],
+		}
+		if brandID, ok := im.brandIDs[car.BrandID]; ok {
+			car.BrandID = brandID
+		}
+		if err := car.Validate(); err != nil {
+			return err
+		}
+
+		if im.opts.DryRun {
+			return nil
+		}
+		if im.opts.Upsert {
+			return im.store.Cars().Upsert(ctx, car)
+		}
+		return im.store.Cars().Create(ctx, car)
+	})
+}
+
+// run построчно читает csv, строки с ошибками попадают в отчет и не прерывают импорт
+func (im *Importer) run(r io.Reader, columns int, importRow func(record []string) error) (*Report, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = columns
+	report := new(Report)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return report, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+			report.Failed = append(report.Failed, RowError{Line: parseErr.StartLine, Err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if err := importRow(record); err != nil {
+			report.Failed = append(report.Failed, RowError{Line: line, Err: err})
+			continue
+		}
+		report.Imported++
+	}
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"strings"
+	"time"
+)
+
+type (
+	// APIKey ключ интеграции, хранится только хэш. Prefix показывается пользователю, чтобы отличать ключи
+	APIKey struct {
+		ID         int         `json:"id" db:"id"`
+		UserID     int         `json:"user_id" db:"user_id"`
+		Name       string      `json:"name" db:"name"`
+		Prefix     string      `json:"prefix" db:"prefix"`
+		KeyHash    string      `json:"-" db:"key_hash"`
+		Scopes     Permissions `json:"scopes" db:"scopes"`
+		CreatedAt  time.Time   `json:"created_at" db:"created_at"`
+		LastUsedAt *time.Time  `json:"last_used_at" db:"last_used_at"`
+		RevokedAt  *time.Time  `json:"revoked_at,omitempty" db:"revoked_at"`
+	}
+
+	CreateAPIKeyDTO struct {
+		Name   string      `json:"name"`
+		Scopes Permissions `json:"scopes"`
+	}
+
+	// APIKeyCreated ответ на создание ключа, сам ключ показывается один раз
+	APIKeyCreated struct {
+		Key    string  `json:"key"`
+		APIKey *APIKey `json:"api_key"`
+	}
+
+	// Permissions хранится в базе строкой через запятую
+	Permissions []Permission
+)
+
+func (k *APIKey) OwnerID() int {
+	return k.UserID
+}
+
+// Intersect права ключа, которые еще есть у роли владельца. Результат не nil,
+// иначе AuthorizedInfo.Can возьмет права роли целиком
+func (p Permissions) Intersect(allowed []Permission) Permissions {
+	result := Permissions{}
+	for _, permission := range p {
+		for _, a := range allowed {
+			if permission == a {
+				result = append(result, permission)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func (d *CreateAPIKeyDTO) Validate() error {
+	return validation.ValidateStruct(
+		d,
+		validation.Field(&d.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&d.Scopes, validation.Required, validation.By(func(value interface{}) error {
+			for _, scope := range value.(Permissions) {
+				if !scope.Valid() {
+					return fmt.Errorf("unknown permission %q", scope)
+				}
+			}
+			return nil
+		})))
+}
+
+func (p Permissions) Value() (driver.Value, error) {
+	scopes := make([]string, len(p))
+	for i, permission := range p {
+		scopes[i] = string(permission)
+	}
+	return strings.Join(scopes, ","), nil
+}
+
+func (p *Permissions) Scan(src interface{}) error {
+	var s string
+	switch src := src.(type) {
+	case string:
+		s = src
+	case []byte:
+		s = string(src)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into Permissions", src)
+	}
+
+	*p = Permissions{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			*p = append(*p, Permission(scope))
+		}
+	}
+	return nil
+}
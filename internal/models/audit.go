@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type (
+	// LoginAttempt состояние счетчика неудачных входов по ключу (ip:... или email:...)
+	LoginAttempt struct {
+		Key           string     `db:"key"`
+		Failures      int        `db:"failures"`
+		LastFailureAt time.Time  `db:"last_failure_at"`
+		BlockedUntil  time.Time  `db:"blocked_until"`
+		LockedUntil   *time.Time `db:"locked_until"`
+	}
+
+	// AuditEvent запись журнала событий безопасности
+	AuditEvent struct {
+		ID        int       `json:"id" db:"id"`
+		Event     string    `json:"event" db:"event"`
+		Subject   string    `json:"subject" db:"subject"`
+		IP        string    `json:"ip" db:"ip"`
+		ActorID   *int      `json:"actor_id,omitempty" db:"actor_id"`
+		CreatedAt time.Time `json:"created_at" db:"created_at"`
+	}
+)
+
+// Empty true, если счетчик можно не хранить
+func (a *LoginAttempt) Empty() bool {
+	return a.Failures == 0 && a.LockedUntil == nil
+}
@@ -1,5 +1,7 @@
 package models
 
+import validation "github.com/go-ozzo/ozzo-validation"
+
 type (
 	Brand struct {
 		ID   int    `json:"id" db:"id"`
@@ -8,5 +10,13 @@ type (
 
 	BrandFilter struct {
 		Query *string `json:"query"`
+		Limit int     `json:"limit"`
+		After *Cursor `json:"-"`
 	}
 )
+
+func (b *Brand) Validate() error {
+	return validation.ValidateStruct(
+		b,
+		validation.Field(&b.Name, validation.Required, validation.Length(1, 255)))
+}
@@ -1,5 +1,13 @@
 package models
 
+import (
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type (
 	Car struct {
 		ID          int    `json:"id" db:"id"`
@@ -13,7 +21,175 @@ type (
 	}
 
 	CarFilter struct {
-		Query *string `json:"query"`
-		CarId *int    `json:"id"`
+		Query     *string   `json:"query"`
+		BrandIDs  []int     `json:"brand_ids"`
+		Cities    []string  `json:"cities"`
+		YearFrom  *int      `json:"year_from"`
+		YearTo    *int      `json:"year_to"`
+		PriceFrom *int      `json:"price_from"`
+		PriceTo   *int      `json:"price_to"`
+		Sort      []SortKey `json:"sort"`
+		Limit     int       `json:"limit"`
+		After     *Cursor   `json:"-"`
+	}
+
+	// CarPatch частичное обновление объявления: nil поля не меняются
+	CarPatch struct {
+		Model       *string `json:"model"`
+		BrandID     *int    `json:"brand_id"`
+		City        *string `json:"city"`
+		Year        *int    `json:"year"`
+		Price       *int    `json:"price"`
+		Description *string `json:"description"`
+	}
+
+	SortKey struct {
+		Field string `json:"field"`
+		Desc  bool   `json:"desc"`
 	}
 )
+
+// CarSortFields поля, по которым разрешена сортировка объявлений
+var CarSortFields = map[string]bool{
+	"id":       true,
+	"model":    true,
+	"brand_id": true,
+	"city":     true,
+	"year":     true,
+	"price":    true,
+}
+
+// ParseCarSort разбирает строку вида "year:desc,price" или "price-asc".
+// Направление по умолчанию asc, неизвестные поля возвращают ошибку
+func ParseCarSort(s string) ([]SortKey, error) {
+	keys := make([]SortKey, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		field, direction := part, "asc"
+		if i := strings.LastIndexAny(part, ":-"); i > 0 {
+			field, direction = part[:i], part[i+1:]
+		}
+		if !CarSortFields[field] {
+			return nil, fmt.Errorf("unknown sort key %q", field)
+		}
+		if direction != "asc" && direction != "desc" {
+			return nil, fmt.Errorf("unknown sort direction %q", direction)
+		}
+		keys = append(keys, SortKey{Field: field, Desc: direction == "desc"})
+	}
+	return keys, nil
+}
+
+func (c *Car) OwnerID() int {
+	return c.UserId
+}
+
+func (c *Car) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Model, validation.Required, validation.Length(1, 255)),
+		validation.Field(&c.BrandID, validation.Required, validation.Min(1)),
+		validation.Field(&c.City, validation.Required, validation.Length(1, 255)),
+		validation.Field(&c.Year, validation.Required, validation.Min(1900), validation.Max(time.Now().Year()+1)),
+		validation.Field(&c.Price, validation.Required, validation.Min(1)))
+}
+
+func (f *CarFilter) Validate() error {
+	for _, key := range f.Sort {
+		if !CarSortFields[key.Field] {
+			return fmt.Errorf("unknown sort key %q", key.Field)
+		}
+	}
+	if f.YearFrom != nil && f.YearTo != nil && *f.YearFrom > *f.YearTo {
+		return fmt.Errorf("year_from is greater than year_to")
+	}
+	if f.PriceFrom != nil && f.PriceTo != nil && *f.PriceFrom > *f.PriceTo {
+		return fmt.Errorf("price_from is greater than price_to")
+	}
+	return nil
+}
+
+func (p *CarPatch) Apply(car *Car) {
+	if p.Model != nil {
+		car.Model = *p.Model
+	}
+	if p.BrandID != nil {
+		car.BrandID = *p.BrandID
+	}
+	if p.City != nil {
+		car.City = *p.City
+	}
+	if p.Year != nil {
+		car.Year = *p.Year
+	}
+	if p.Price != nil {
+		car.Price = *p.Price
+	}
+	if p.Description != nil {
+		car.Description = *p.Description
+	}
+}
+
+// SortValue значение поля, по которому разрешена сортировка
+func (c *Car) SortValue(field string) interface{} {
+	switch field {
+	case "model":
+		return c.Model
+	case "brand_id":
+		return c.BrandID
+	case "city":
+		return c.City
+	case "year":
+		return c.Year
+	case "price":
+		return c.Price
+	}
+	return c.ID
+}
+
+func NewCarCursor(car *Car, keys []SortKey) *Cursor {
+	cursor := &Cursor{Sort: SortSignature(keys), ID: car.ID}
+	for _, key := range keys {
+		cursor.Values = append(cursor.Values, fmt.Sprint(car.SortValue(key.Field)))
+	}
+	return cursor
+}
+
+// CursorCar восстанавливает из курсора поля сортировки последней машины страницы.
+// Курсор, выданный для другой сортировки, считается невалидным
+func CursorCar(cursor *Cursor, keys []SortKey) (*Car, error) {
+	if cursor.Sort != SortSignature(keys) || len(cursor.Values) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+
+	car := &Car{ID: cursor.ID}
+	for i, key := range keys {
+		value := cursor.Values[i]
+		switch key.Field {
+		case "model":
+			car.Model = value
+		case "city":
+			car.City = value
+		default:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			switch key.Field {
+			case "id":
+				car.ID = n
+			case "brand_id":
+				car.BrandID = n
+			case "year":
+				car.Year = n
+			case "price":
+				car.Price = n
+			}
+		}
+	}
+	return car, nil
+}
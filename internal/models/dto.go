@@ -12,11 +12,19 @@ type Tokens struct {
 	RefreshToken string
 }
 
-type Session struct {
-	RefreshToken string    `json:"refreshToken" db:"refreshToken"`
-	ExpiresAt    time.Time `json:"expiresAt" db:"expiresAt"`
-}
 type AuthorizedInfo struct {
-	Id   int  `json:"id"`
+	Id          int          `json:"id"`
+	Role        Role         `json:"role"`
+	Permissions []Permission `json:"perms,omitempty"`
+	SessionID   int          `json:"sid,omitempty"`
+
+	// TokenID и ExpiresAt берутся из jti и exp токена
+	TokenID   string    `json:"-"`
+	ExpiresAt time.Time `json:"-"`
+	// APIKeyID ключ, которым аутентифицирован запрос, 0 для JWT
+	APIKeyID int `json:"-"`
+}
+
+type RoleDTO struct {
 	Role Role `json:"role"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Identity аккаунт пользователя у внешнего OIDC провайдера. Subject постоянен у провайдера, email может меняться
+type Identity struct {
+	Issuer    string    `json:"issuer" db:"issuer"`
+	Subject   string    `json:"subject" db:"subject"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Email     string    `json:"email" db:"email"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type (
+	// Page ответ со списком и курсором на следующую страницу, пустой курсор - последняя страница
+	Page[T any] struct {
+		Items      []T    `json:"items"`
+		NextCursor string `json:"next_cursor,omitempty"`
+	}
+
+	// Cursor позиция последней отданной записи: значения ключей сортировки и id
+	Cursor struct {
+		Sort   string   `json:"s,omitempty"`
+		Values []string `json:"v,omitempty"`
+		ID     int      `json:"id"`
+	}
+)
+
+// NewPage обрезает items до limit. Репозитории запрашивают limit+1 записей,
+// лишняя запись означает, что есть следующая страница
+func NewPage[T any](items []T, limit int, cursor func(item T) *Cursor) *Page[T] {
+	page := &Page[T]{Items: items}
+	if limit > 0 && len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = cursor(page.Items[limit-1]).Encode()
+	}
+	return page
+}
+
+func (c *Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := new(Cursor)
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// SortSignature описание сортировки, по которому курсор сверяется с запросом
+func SortSignature(keys []SortKey) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		direction := "asc"
+		if key.Desc {
+			direction = "desc"
+		}
+		parts = append(parts, key.Field+":"+direction)
+	}
+	return strings.Join(parts, ",")
+}
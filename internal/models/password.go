@@ -0,0 +1,38 @@
+package models
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"time"
+)
+
+type (
+	// PasswordReset одноразовый токен сброса пароля, хранится только хэш
+	PasswordReset struct {
+		TokenHash string     `db:"token_hash"`
+		UserID    int        `db:"user_id"`
+		CreatedAt time.Time  `db:"created_at"`
+		ExpiresAt time.Time  `db:"expires_at"`
+		UsedAt    *time.Time `db:"used_at"`
+	}
+
+	ForgotPasswordDTO struct {
+		Email string `json:"email"`
+	}
+
+	ResetPasswordDTO struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+)
+
+func (f *ForgotPasswordDTO) Validate() error {
+	return validation.ValidateStruct(f, validation.Field(&f.Email, validation.Required, validation.Length(0, MaxEmailLength), is.Email))
+}
+
+func (r *ResetPasswordDTO) Validate() error {
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.Token, validation.Required),
+		validation.Field(&r.Password, validation.Required, validation.Length(6, 50)))
+}
@@ -0,0 +1,63 @@
+package models
+
+// Permission право на группу действий, входит в JWT
+type Permission string
+
+const (
+	// PermCarsWrite создание машин и изменение своих объявлений
+	PermCarsWrite Permission = "cars:write"
+	// PermCarsModerate изменение и удаление чужих объявлений
+	PermCarsModerate Permission = "cars:moderate"
+	PermBrandsWrite  Permission = "brands:write"
+	PermUsersRead    Permission = "users:read"
+	// PermUsersManage удаление пользователей, смена ролей, снятие блокировок и отзыв чужих сессий
+	PermUsersManage Permission = "users:manage"
+)
+
+var allPermissions = []Permission{PermCarsWrite, PermCarsModerate, PermBrandsWrite, PermUsersRead, PermUsersManage}
+
+func (p Permission) Valid() bool {
+	for _, known := range allPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// rolePermissions матрица прав ролей
+var rolePermissions = map[Role][]Permission{
+	Admin:     {PermCarsWrite, PermCarsModerate, PermBrandsWrite, PermUsersRead, PermUsersManage},
+	Moderator: {PermCarsWrite, PermCarsModerate, PermUsersRead},
+	Dealer:    {PermCarsWrite},
+	Client:    {PermCarsWrite},
+}
+
+// RequiresTwoFactor роли, которым нельзя входить без второго фактора
+func (r Role) RequiresTwoFactor() bool {
+	return r == Admin
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) Permissions() []Permission {
+	return append([]Permission(nil), rolePermissions[r]...)
+}
+
+// Can проверяет право по списку из токена. У токенов, выданных до появления прав,
+// списка нет, для них права берутся из роли
+func (i *AuthorizedInfo) Can(permission Permission) bool {
+	permissions := i.Permissions
+	if permissions == nil {
+		permissions = rolePermissions[i.Role]
+	}
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
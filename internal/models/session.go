@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+type (
+	// Session вход пользователя с одного устройства. Refresh токены сессии ротируются,
+	// сама сессия живет до выхода, отзыва или истечения срока
+	Session struct {
+		ID         int        `json:"id" db:"id"`
+		UserID     int        `json:"user_id" db:"user_id"`
+		UserAgent  string     `json:"user_agent" db:"user_agent"`
+		IP         string     `json:"ip" db:"ip"`
+		CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+		LastUsedAt time.Time  `json:"last_used_at" db:"last_used_at"`
+		ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+		RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+		// AccessTokenID jti последнего выданного сессии access токена, он отзывается вместе с сессией
+		AccessTokenID string `json:"-" db:"access_token_id"`
+		Current       bool   `json:"current" db:"-"`
+	}
+
+	// RefreshToken хранится только в виде хэша
+	RefreshToken struct {
+		Hash      string     `json:"-" db:"token_hash"`
+		SessionID int        `json:"-" db:"session_id"`
+		CreatedAt time.Time  `json:"-" db:"created_at"`
+		ExpiresAt time.Time  `json:"-" db:"expires_at"`
+		UsedAt    *time.Time `json:"-" db:"used_at"`
+	}
+
+	RefreshDTO struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+)
+
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+func (s *Session) OwnerID() int {
+	return s.UserID
+}
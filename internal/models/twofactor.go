@@ -0,0 +1,66 @@
+package models
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"time"
+)
+
+type (
+	// TwoFactor TOTP секрет пользователя. Пока EnabledAt пуст, секрет ждет подтверждения кодом
+	TwoFactor struct {
+		UserID    int        `db:"user_id"`
+		Secret    string     `db:"secret"`
+		CreatedAt time.Time  `db:"created_at"`
+		EnabledAt *time.Time `db:"enabled_at"`
+		// LastStep последний принятый шаг TOTP, коды этого и более ранних шагов не принимаются
+		LastStep int64 `db:"last_step"`
+	}
+
+	TwoFactorEnrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+
+	TwoFactorCodeDTO struct {
+		Code string `json:"code"`
+	}
+
+	RecoveryCodes struct {
+		Codes []string `json:"recovery_codes"`
+	}
+
+	// LoginChallenge первый шаг входа с 2FA. Step: code - нужен код, enroll - нужно сначала подключить 2FA
+	LoginChallenge struct {
+		ChallengeToken string `json:"challenge_token"`
+		Step           string `json:"two_factor"`
+		ExpiresIn      int    `json:"expires_in"`
+	}
+
+	// TwoFactorLoginDTO второй шаг входа: код из приложения или один из кодов восстановления
+	TwoFactorLoginDTO struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	// TwoFactorLogin токены после второго шага. Коды восстановления приходят, если 2FA подключена при входе
+	TwoFactorLogin struct {
+		*Tokens
+		RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	}
+)
+
+func (t *TwoFactor) Enabled() bool {
+	return t.EnabledAt != nil
+}
+
+func (d *TwoFactorCodeDTO) Validate() error {
+	return validation.ValidateStruct(d, validation.Field(&d.Code, validation.Required))
+}
+
+func (d *TwoFactorLoginDTO) Validate() error {
+	return validation.ValidateStruct(
+		d,
+		validation.Field(&d.ChallengeToken, validation.Required),
+		validation.Field(&d.Code, validation.By(RequiredIf(d.RecoveryCode == ""))))
+}
@@ -4,15 +4,21 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 type Role string
 
 const (
-	Admin  Role = "admin"
-	Client Role = "client"
+	Admin     Role = "admin"
+	Moderator Role = "moderator"
+	Dealer    Role = "dealer"
+	Client    Role = "client"
 )
 
+// MaxEmailLength длиннее адрес электронной почты быть не может (RFC 5321)
+const MaxEmailLength = 254
+
 type User struct {
 	ID                int    `json:"id" db:"id"`
 	Name              string `json:"name" db:"name"`
@@ -23,12 +29,37 @@ type User struct {
 	PhoneNumber       string `json:"phone_number" db:"phone_number"`
 	BirthDate         string `json:"birth_date" db:"birth_date"`
 	Role              *Role  `json:"role" db:"role"`
+
+	VerifiedAt         *time.Time `json:"verified_at" db:"verified_at"`
+	VerificationSentAt *time.Time `json:"-" db:"verification_sent_at"`
+}
+
+// UserFilter пользователи отдаются страницами по возрастанию id
+type UserFilter struct {
+	Limit int     `json:"limit"`
+	After *Cursor `json:"-"`
+}
+
+func (u *User) OwnerID() int {
+	return u.ID
+}
+
+// GetRole роль пользователя, у записей без роли это Client
+func (u *User) GetRole() Role {
+	if u.Role == nil {
+		return Client
+	}
+	return *u.Role
+}
+
+func (u *User) Verified() bool {
+	return u.VerifiedAt != nil
 }
 
 func (u *User) Validate() error {
 	return validation.ValidateStruct(
 		u,
-		validation.Field(&u.Email, validation.Required, is.Email),
+		validation.Field(&u.Email, validation.Required, validation.Length(0, MaxEmailLength), is.Email),
 		validation.Field(&u.Password, validation.By(RequiredIf(u.EncryptedPassword == "")),
 			validation.Length(6, 50)))
 }
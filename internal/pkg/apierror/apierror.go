@@ -0,0 +1,82 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/middleware"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"net/http"
+)
+
+// Коды ошибок, на которые ориентируются клиенты
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidBody        = "invalid_body"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeInsufficientRights = "insufficient_rights"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeInvalidReference   = "invalid_reference"
+	CodeInvalidCursor      = "invalid_cursor"
+	CodeInvalidToken       = "invalid_refresh_token"
+	CodeTokenReused        = "refresh_token_reused"
+	CodeInvalidResetToken  = "invalid_reset_token"
+	CodeInvalidLink        = "invalid_link"
+	CodeEmailNotVerified   = "email_not_verified"
+	CodeAlreadyVerified    = "already_verified"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeInvalidChallenge   = "invalid_challenge"
+	CodeInvalidTwoFactor   = "invalid_two_factor_code"
+	CodeTwoFactorRequired  = "two_factor_required"
+	CodeInvalidOIDCState   = "invalid_oidc_state"
+	CodeOIDCFailed         = "oidc_failed"
+	CodeInternal           = "internal_error"
+)
+
+// Error тело любого ответа с ошибкой
+type Error struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+func Write(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&Error{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: middleware.GetReqID(r.Context()),
+	})
+}
+
+// Validation отвечает 422, ошибки ozzo-validation раскладываются по полям в details
+func Validation(w http.ResponseWriter, r *http.Request, err error) {
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		Write(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, err.Error(), nil)
+		return
+	}
+
+	details := make(map[string]string)
+	flatten(details, "", errs)
+	Write(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "Validation failed", details)
+}
+
+func flatten(details map[string]string, prefix string, errs validation.Errors) {
+	for field, err := range errs {
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+		var nested validation.Errors
+		if errors.As(err, &nested) {
+			flatten(details, field, nested)
+			continue
+		}
+		details[field] = err.Error()
+	}
+}
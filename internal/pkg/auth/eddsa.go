@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"sync"
+)
+
+// SigningMethodEdDSA подпись Ed25519 (RFC 8037), которой нет в jwt-go v3
+var SigningMethodEdDSA = &signingMethodEd25519{}
+
+var errEd25519Verification = errors.New("ed25519: verification error")
+
+var registerEdDSA sync.Once
+
+type signingMethodEd25519 struct{}
+
+// RegisterEdDSA добавляет EdDSA в реестр jwt-go, без этого парсер не принимает такие токены.
+// Вызывать можно сколько угодно раз
+func RegisterEdDSA() {
+	registerEdDSA.Do(func() {
+		jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+			return SigningMethodEdDSA
+		})
+	})
+}
+
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errEd25519Verification
+	}
+	return nil
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
@@ -1,11 +1,15 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
-	"math/rand"
 	"project/internal/models"
+	"strings"
 	"time"
 )
 
@@ -13,11 +17,12 @@ type TokenManager interface {
 	NewJWT(userInfo *models.AuthorizedInfo, ttl time.Duration) (string, error)
 	Parse(accessToken string) (*models.AuthorizedInfo, error)
 	NewRefreshToken() (string, error)
+	JWKS() JWKS
 }
 
 type (
 	Manager struct {
-		signingKey string
+		keys *KeySet
 	}
 	tokenClaims struct {
 		jwt.StandardClaims
@@ -25,50 +30,121 @@ type (
 	}
 )
 
+// NewManager подписывает токены HS256 общим секретом, токены выдаются без kid
 func NewManager(signingKey string) (TokenManager, error) {
 	if signingKey == "" {
 		return nil, errors.New(("error: empty signing key"))
 	}
-	return &Manager{signingKey: signingKey}, nil
+	keys, err := NewKeySet(NewHMACKey("", []byte(signingKey)))
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{keys: keys}, nil
+}
+
+// NewKeySetManager подписывает токены текущим ключом набора и проверяет их по kid
+func NewKeySetManager(keys *KeySet) TokenManager {
+	return &Manager{keys: keys}
 }
 
 func (m Manager) NewJWT(userInfo *models.AuthorizedInfo, ttl time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{
+	key, err := m.keys.signingKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, &tokenClaims{
 		StandardClaims: jwt.StandardClaims{
+			Id:        userInfo.TokenID,
 			ExpiresAt: time.Now().Add(ttl).Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
 		User: userInfo,
 	})
-	return token.SignedString([]byte(m.signingKey))
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.private)
 }
 
 func (m Manager) Parse(accessToken string) (*models.AuthorizedInfo, error) {
 	token, err := jwt.ParseWithClaims(accessToken, &tokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys.verificationKey(kid, time.Now())
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key : %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method : %v", token.Header["alg"])
 		}
-		return []byte(m.signingKey), nil
+		return key.public, nil
 	})
 	if err != nil {
 		return nil, err
 	}
 	claims, ok := token.Claims.(*tokenClaims)
-	if !ok {
+	if !ok || claims.User == nil {
 		return nil, fmt.Errorf("error in getting user claims from tokens")
 	}
+	claims.User.TokenID = claims.Id
+	claims.User.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
 	return claims.User, nil
 }
 
 func (m Manager) NewRefreshToken() (string, error) {
 	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
 
-	s := rand.NewSource(time.Now().Unix())
-	r := rand.New(s)
+	return fmt.Sprintf("%x", b), nil
+}
 
-	if _, err := r.Read(b); err != nil {
+func (m Manager) JWKS() JWKS {
+	return m.keys.JWKS(time.Now())
+}
+
+// NewTokenID случайный jti для access токена
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(b), nil
+}
 
-	return fmt.Sprintf("%x", b), nil
+// HashToken возвращает sha256 токена. В базе хранятся только хэши
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyPrefix отличает ключи интеграций от прочих секретов, например в логах и сканерах
+const APIKeyPrefix = "ck_"
+
+// NewAPIKey возвращает ключ и его видимое начало, по которому пользователь узнает ключ в списке
+func NewAPIKey() (key, prefix string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + hex.EncodeToString(b)
+	return key, key[:len(APIKeyPrefix)+8], nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewRecoveryCode одноразовый код восстановления вида abcde-fghij
+func NewRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// HashRecoveryCode хэш кода без учета регистра, пробелов и дефиса
+func HashRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	return HashToken(code)
 }
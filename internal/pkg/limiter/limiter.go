@@ -0,0 +1,114 @@
+package limiter
+
+import (
+	"context"
+	"project/internal/models"
+	"time"
+)
+
+type Event string
+
+const (
+	NoEvent Event = ""
+	Lockout Event = "login_lockout"
+	Unlock  Event = "login_unlock"
+)
+
+// Status результат обращения к счетчику
+type Status struct {
+	// RetryAfter через сколько можно снова пробовать, 0 - можно сейчас
+	RetryAfter time.Duration
+	// Event блокировка или разблокировка, произошедшая при этом обращении, для журнала аудита
+	Event Event
+}
+
+// Limiter считает неудачные попытки входа по ключу
+type Limiter interface {
+	// Check сообщает, можно ли сейчас пробовать войти
+	Check(ctx context.Context, key string) (Status, error)
+	// Fail учитывает неудачную попытку
+	Fail(ctx context.Context, key string) (Status, error)
+	// Reset сбрасывает счетчик после успешного входа или ручной разблокировки
+	Reset(ctx context.Context, key string) (Status, error)
+}
+
+// Policy после FreeAttempts неудач каждая следующая удваивает задержку от BaseDelay до MaxDelay.
+// После LockoutAfter неудач ключ блокируется на LockoutFor. Счетчик забывается через ResetAfter без неудач
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockoutAfter int // 0 - без блокировки
+	LockoutFor   time.Duration
+	ResetAfter   time.Duration
+}
+
+var (
+	// DefaultIPPolicy только замедляет перебор с одного адреса, за одним IP может быть много пользователей
+	DefaultIPPolicy = Policy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+	// DefaultEmailPolicy блокирует аккаунт после 10 неудач подряд
+	DefaultEmailPolicy = Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockoutAfter: 10,
+		LockoutFor:   30 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+)
+
+// check снимает истекшую блокировку и забывает старые неудачи
+func (p Policy) check(a *models.LoginAttempt, now time.Time) Status {
+	if a.LockedUntil != nil && !now.Before(*a.LockedUntil) {
+		*a = models.LoginAttempt{Key: a.Key}
+		return Status{Event: Unlock}
+	}
+	if a.LockedUntil == nil && p.ResetAfter > 0 && now.Sub(a.LastFailureAt) >= p.ResetAfter {
+		*a = models.LoginAttempt{Key: a.Key}
+	}
+	return Status{RetryAfter: positive(a.BlockedUntil.Sub(now))}
+}
+
+func (p Policy) fail(a *models.LoginAttempt, now time.Time) Status {
+	status := p.check(a, now)
+	a.Failures++
+	a.LastFailureAt = now
+
+	if p.LockoutAfter > 0 && a.Failures >= p.LockoutAfter && a.LockedUntil == nil {
+		lockedUntil := now.Add(p.LockoutFor)
+		a.LockedUntil, a.BlockedUntil = &lockedUntil, lockedUntil
+		return Status{RetryAfter: p.LockoutFor, Event: Lockout}
+	}
+	if a.Failures > p.FreeAttempts {
+		delay := p.BaseDelay << uint(a.Failures-p.FreeAttempts-1)
+		if delay > p.MaxDelay || delay <= 0 {
+			delay = p.MaxDelay
+		}
+		if blockedUntil := now.Add(delay); blockedUntil.After(a.BlockedUntil) {
+			a.BlockedUntil = blockedUntil
+		}
+	}
+	status.RetryAfter = positive(a.BlockedUntil.Sub(now))
+	return status
+}
+
+func (p Policy) reset(a *models.LoginAttempt) Status {
+	locked := a.LockedUntil != nil
+	*a = models.LoginAttempt{Key: a.Key}
+	if locked {
+		return Status{Event: Unlock}
+	}
+	return Status{}
+}
+
+func positive(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"context"
+	"project/internal/models"
+	"sync"
+	"time"
+)
+
+// Memory хранит счетчики в памяти процесса. Подходит для одного экземпляра сервера
+type Memory struct {
+	policy Policy
+
+	mu        sync.Mutex
+	attempts  map[string]*models.LoginAttempt
+	lastSweep time.Time
+}
+
+func NewMemory(policy Policy) *Memory {
+	return &Memory{
+		policy:    policy,
+		attempts:  make(map[string]*models.LoginAttempt),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *Memory) Check(ctx context.Context, key string) (Status, error) {
+	return m.update(key, m.policy.check), nil
+}
+
+func (m *Memory) Fail(ctx context.Context, key string) (Status, error) {
+	return m.update(key, m.policy.fail), nil
+}
+
+func (m *Memory) Reset(ctx context.Context, key string) (Status, error) {
+	return m.update(key, func(a *models.LoginAttempt, now time.Time) Status {
+		return m.policy.reset(a)
+	}), nil
+}
+
+func (m *Memory) update(key string, fn func(a *models.LoginAttempt, now time.Time) Status) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+	attempt, ok := m.attempts[key]
+	if !ok {
+		attempt = &models.LoginAttempt{Key: key}
+	}
+	status := fn(attempt, now)
+	if attempt.Empty() {
+		delete(m.attempts, key)
+	} else {
+		m.attempts[key] = attempt
+	}
+	return status
+}
+
+// sweep раз в ResetAfter удаляет забытые счетчики, чтобы перебор по случайным email не раздувал память
+func (m *Memory) sweep(now time.Time) {
+	if m.policy.ResetAfter <= 0 || now.Sub(m.lastSweep) < m.policy.ResetAfter {
+		return
+	}
+	m.lastSweep = now
+	for key, attempt := range m.attempts {
+		if attempt.LockedUntil == nil && now.Sub(attempt.LastFailureAt) >= m.policy.ResetAfter {
+			delete(m.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package limiter
+
+import (
+	"context"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+// Shared хранит счетчики в хранилище, поэтому все экземпляры сервера видят одни и те же попытки
+type Shared struct {
+	policy   Policy
+	attempts store.LoginAttemptsRepository
+}
+
+func NewShared(attempts store.LoginAttemptsRepository, policy Policy) *Shared {
+	return &Shared{policy: policy, attempts: attempts}
+}
+
+// Check только читает счетчик: каждая попытка входа не должна писать в хранилище.
+// Истекшую блокировку снимает и отмечает в журнале следующий Fail или Reset
+func (s *Shared) Check(ctx context.Context, key string) (Status, error) {
+	attempt, err := s.attempts.Get(ctx, key)
+	if err != nil {
+		return Status{}, err
+	}
+	status := s.policy.check(attempt, time.Now())
+	status.Event = NoEvent
+	return status, nil
+}
+
+func (s *Shared) Fail(ctx context.Context, key string) (Status, error) {
+	return s.update(ctx, key, s.policy.fail)
+}
+
+func (s *Shared) Reset(ctx context.Context, key string) (Status, error) {
+	return s.update(ctx, key, func(a *models.LoginAttempt, now time.Time) Status {
+		return s.policy.reset(a)
+	})
+}
+
+func (s *Shared) update(ctx context.Context, key string, fn func(a *models.LoginAttempt, now time.Time) Status) (Status, error) {
+	var status Status
+	err := s.attempts.Update(ctx, key, func(a *models.LoginAttempt) {
+		status = fn(a, time.Now())
+	})
+	return status, err
+}
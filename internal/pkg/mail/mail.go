@@ -0,0 +1,27 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var errHeaderInjection = errors.New("mail: header contains a line break")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer отправляет письма пользователям. Реализации: SMTP для продакшена, файл и лог для локального запуска
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+func (m *Message) validate() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errHeaderInjection
+	}
+	return nil
+}
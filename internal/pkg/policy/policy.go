@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"errors"
+	"project/internal/models"
+)
+
+type Action string
+
+const (
+	Read   Action = "read"
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Owned ресурс, у которого есть владелец
+type Owned interface {
+	OwnerID() int
+}
+
+// ownerActions действия, которые владелец может выполнять над своим ресурсом
+var ownerActions = map[Action]bool{
+	Read:   true,
+	Update: true,
+	Delete: true,
+}
+
+// Authorize решает, может ли пользователь выполнить действие над ресурсом.
+// Владельцу разрешены действия над своим ресурсом, остальным - только с правом на модерацию ресурса
+func Authorize(user *models.AuthorizedInfo, action Action, resource interface{}) error {
+	if user == nil {
+		return ErrForbidden
+	}
+	if owned, ok := resource.(Owned); ok && owned.OwnerID() == user.Id && ownerActions[action] && ownerAllowed(user, resource) {
+		return nil
+	}
+	if permission, ok := moderatePermission(resource); ok && user.Can(permission) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// moderatePermission право на действия с чужими ресурсами этого типа
+func moderatePermission(resource interface{}) (models.Permission, bool) {
+	switch resource.(type) {
+	case *models.Car:
+		return models.PermCarsModerate, true
+	case *models.User, *models.Session, *models.APIKey:
+		return models.PermUsersManage, true
+	default:
+		return "", false
+	}
+}
+
+// ownerAllowed ключ API действует над ресурсами владельца только в пределах своих scopes,
+// иначе ключ с одним cars:write мог бы удалить аккаунт или его сессии
+func ownerAllowed(user *models.AuthorizedInfo, resource interface{}) bool {
+	if user.APIKeyID == 0 {
+		return true
+	}
+	switch resource.(type) {
+	case *models.Car:
+		return user.Can(models.PermCarsWrite)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,11 @@
+package store
+
+import "errors"
+
+// Ошибки, которые репозитории возвращают вместо ошибок конкретной базы данных
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrConflict         = errors.New("conflict")
+	ErrInvalidReference = errors.New("invalid reference")
+	ErrTokenReused      = errors.New("refresh token reused")
+)
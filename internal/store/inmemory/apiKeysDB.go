@@ -0,0 +1,110 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) APIKeys() store.APIKeysRepository {
+	return db.apiKeys
+}
+
+type APIKeysRepository struct {
+	db *DB
+}
+
+func (a APIKeysRepository) Create(ctx context.Context, key *models.APIKey) error {
+	a.db.mu.Lock()
+	defer a.db.mu.Unlock()
+
+	if _, ok := a.db.usersData[key.UserID]; !ok {
+		return fmt.Errorf("%w: user %d", store.ErrInvalidReference, key.UserID)
+	}
+	for _, stored := range a.db.apiKeysData {
+		if stored.KeyHash == key.KeyHash {
+			return fmt.Errorf("%w: api_keys_key_hash_key", store.ErrConflict)
+		}
+	}
+	a.db.lastAPIKeyID++
+	key.ID = a.db.lastAPIKeyID
+	key.CreatedAt = time.Now()
+	a.db.apiKeysData[key.ID] = copyAPIKey(key)
+	return nil
+}
+
+func (a APIKeysRepository) ByID(ctx context.Context, id int) (*models.APIKey, error) {
+	a.db.mu.RLock()
+	defer a.db.mu.RUnlock()
+
+	key, ok := a.db.apiKeysData[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copyAPIKey(key), nil
+}
+
+func (a APIKeysRepository) ByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	a.db.mu.RLock()
+	defer a.db.mu.RUnlock()
+
+	for _, key := range a.db.apiKeysData {
+		if key.KeyHash == keyHash && key.RevokedAt == nil {
+			return copyAPIKey(key), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (a APIKeysRepository) AllOfUser(ctx context.Context, userID int) ([]*models.APIKey, error) {
+	a.db.mu.RLock()
+	defer a.db.mu.RUnlock()
+
+	keys := make([]*models.APIKey, 0)
+	for id := 1; id <= a.db.lastAPIKeyID; id++ {
+		if key, ok := a.db.apiKeysData[id]; ok && key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+	return keys, nil
+}
+
+func (a APIKeysRepository) Revoke(ctx context.Context, id int, at time.Time) error {
+	a.db.mu.Lock()
+	defer a.db.mu.Unlock()
+
+	key, ok := a.db.apiKeysData[id]
+	if !ok || key.RevokedAt != nil {
+		return store.ErrNotFound
+	}
+	key.RevokedAt = &at
+	return nil
+}
+
+func (a APIKeysRepository) Touch(ctx context.Context, id int, at time.Time) error {
+	a.db.mu.Lock()
+	defer a.db.mu.Unlock()
+
+	key, ok := a.db.apiKeysData[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	key.LastUsedAt = &at
+	return nil
+}
+
+func copyAPIKey(key *models.APIKey) *models.APIKey {
+	k := *key
+	k.Scopes = append(models.Permissions(nil), key.Scopes...)
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		k.LastUsedAt = &lastUsedAt
+	}
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		k.RevokedAt = &revokedAt
+	}
+	return &k
+}
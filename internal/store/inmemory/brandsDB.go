@@ -0,0 +1,100 @@
+package inmemory
+
+import (
+	"context"
+	"project/internal/models"
+	"project/internal/store"
+	"sort"
+)
+
+func (db *DB) Brands() store.BrandsRepository {
+	return db.brands
+}
+
+type BrandsRepository struct {
+	db *DB
+}
+
+func (c BrandsRepository) Create(ctx context.Context, brand *models.Brand) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.lastBrandID++
+	brand.ID = c.db.lastBrandID
+	b := *brand
+	c.db.brandsData[b.ID] = &b
+	return nil
+}
+
+func (c BrandsRepository) Upsert(ctx context.Context, brand *models.Brand) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if brand.ID > c.db.lastBrandID {
+		c.db.lastBrandID = brand.ID
+	}
+	b := *brand
+	c.db.brandsData[b.ID] = &b
+	return nil
+}
+
+func (c BrandsRepository) All(ctx context.Context, filter *models.BrandFilter) (*models.Page[*models.Brand], error) {
+	c.db.mu.RLock()
+	defer c.db.mu.RUnlock()
+
+	brands := make([]*models.Brand, 0, len(c.db.brandsData))
+	for _, brand := range c.db.brandsData {
+		if filter.Query != nil && !ilike(brand.Name, *filter.Query) {
+			continue
+		}
+		if filter.After != nil && brand.ID <= filter.After.ID {
+			continue
+		}
+		b := *brand
+		brands = append(brands, &b)
+	}
+	sort.Slice(brands, func(i, j int) bool { return brands[i].ID < brands[j].ID })
+	return models.NewPage(limit(brands, filter.Limit), filter.Limit, func(brand *models.Brand) *models.Cursor {
+		return &models.Cursor{ID: brand.ID}
+	}), nil
+}
+
+func (c BrandsRepository) ByID(ctx context.Context, id int) (*models.Brand, error) {
+	c.db.mu.RLock()
+	defer c.db.mu.RUnlock()
+
+	brand, ok := c.db.brandsData[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	b := *brand
+	return &b, nil
+}
+
+func (c BrandsRepository) Update(ctx context.Context, brand *models.Brand) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	b, ok := c.db.brandsData[brand.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	b.Name = brand.Name
+	return nil
+}
+
+func (c BrandsRepository) Delete(ctx context.Context, id int) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if _, ok := c.db.brandsData[id]; !ok {
+		return store.ErrNotFound
+	}
+	for _, car := range c.db.carsData {
+		if car.BrandID == id {
+			return store.ErrInvalidReference
+		}
+	}
+	delete(c.db.brandsData, id)
+	return nil
+}
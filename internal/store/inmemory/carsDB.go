@@ -0,0 +1,259 @@
+package inmemory
+
+import (
+	"context"
+	"project/internal/models"
+	"project/internal/store"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (db *DB) Cars() store.CarsRepository {
+	return db.cars
+}
+
+type CarsRepository struct {
+	db *DB
+}
+
+func (c CarsRepository) Create(ctx context.Context, car *models.Car) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if err := c.db.checkCarReferences(car); err != nil {
+		return err
+	}
+	c.db.lastCarID++
+	car.ID = c.db.lastCarID
+	stored := *car
+	c.db.carsData[car.ID] = &stored
+	return nil
+}
+
+func (c CarsRepository) Upsert(ctx context.Context, car *models.Car) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if err := c.db.checkCarReferences(car); err != nil {
+		return err
+	}
+	if car.ID > c.db.lastCarID {
+		c.db.lastCarID = car.ID
+	}
+	stored := *car
+	c.db.carsData[car.ID] = &stored
+	return nil
+}
+
+func (c CarsRepository) All(ctx context.Context, filter *models.CarFilter) (*models.Page[*models.Car], error) {
+	var after *models.Car
+	if filter.After != nil {
+		var err error
+		if after, err = models.CursorCar(filter.After, filter.Sort); err != nil {
+			return nil, err
+		}
+	}
+
+	cars := c.selectCars(func(car *models.Car) bool {
+		return matchCar(car, filter) && (after == nil || compareCars(car, after, filter.Sort) > 0)
+	})
+	sort.SliceStable(cars, func(i, j int) bool {
+		return compareCars(cars[i], cars[j], filter.Sort) < 0
+	})
+	return models.NewPage(limit(cars, filter.Limit), filter.Limit, func(car *models.Car) *models.Cursor {
+		return models.NewCarCursor(car, filter.Sort)
+	}), nil
+}
+
+func (c CarsRepository) AllOfUser(ctx context.Context, userId int) ([]*models.Car, error) {
+	return c.selectCars(func(car *models.Car) bool {
+		return car.UserId == userId
+	}), nil
+}
+
+func (c CarsRepository) ByID(ctx context.Context, id int) (*models.Car, error) {
+	c.db.mu.RLock()
+	defer c.db.mu.RUnlock()
+
+	car, ok := c.db.carsData[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	found := *car
+	return &found, nil
+}
+
+func (c CarsRepository) Update(ctx context.Context, car *models.Car) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	stored, ok := c.db.carsData[car.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	updated := *car
+	updated.UserId = stored.UserId
+	if err := c.db.checkCarReferences(&updated); err != nil {
+		return err
+	}
+	c.db.carsData[car.ID] = &updated
+	return nil
+}
+
+func (c CarsRepository) Delete(ctx context.Context, id int) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if _, ok := c.db.carsData[id]; !ok {
+		return store.ErrNotFound
+	}
+	c.db.deleteCar(id)
+	return nil
+}
+
+func (c CarsRepository) AddToFav(ctx context.Context, userId, carId int) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if _, ok := c.db.carsData[carId]; !ok {
+		return store.ErrNotFound
+	}
+	if c.db.favourites[userId] == nil {
+		c.db.favourites[userId] = make(map[int]time.Time)
+	}
+	if _, ok := c.db.favourites[userId][carId]; !ok {
+		c.db.favourites[userId][carId] = time.Now()
+	}
+	return nil
+}
+
+func (c CarsRepository) DeleteFromFav(ctx context.Context, userId, carId int) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if _, ok := c.db.favourites[userId][carId]; !ok {
+		return store.ErrNotFound
+	}
+	delete(c.db.favourites[userId], carId)
+	return nil
+}
+
+func (c CarsRepository) ShowFav(ctx context.Context, userId int) ([]*models.Car, error) {
+	c.db.mu.RLock()
+	defer c.db.mu.RUnlock()
+
+	favourites := c.db.favourites[userId]
+	favouriteCars := make([]*models.Car, 0, len(favourites))
+	for id := range favourites {
+		if car, ok := c.db.carsData[id]; ok {
+			favourite := *car
+			favouriteCars = append(favouriteCars, &favourite)
+		}
+	}
+	sort.Slice(favouriteCars, func(i, j int) bool {
+		a, b := favourites[favouriteCars[i].ID], favourites[favouriteCars[j].ID]
+		if a.Equal(b) {
+			return favouriteCars[i].ID < favouriteCars[j].ID
+		}
+		return a.After(b)
+	})
+	return favouriteCars, nil
+}
+
+// selectCars возвращает копии машин, удовлетворяющих условию, в порядке id
+func (c CarsRepository) selectCars(match func(car *models.Car) bool) []*models.Car {
+	c.db.mu.RLock()
+	defer c.db.mu.RUnlock()
+
+	cars := make([]*models.Car, 0)
+	for _, car := range c.db.carsData {
+		if match(car) {
+			found := *car
+			cars = append(cars, &found)
+		}
+	}
+	sort.Slice(cars, func(i, j int) bool { return cars[i].ID < cars[j].ID })
+	return cars
+}
+
+func matchCar(car *models.Car, filter *models.CarFilter) bool {
+	if filter.Query != nil && !ilike(car.Model, *filter.Query) && !ilike(car.Description, *filter.Query) {
+		return false
+	}
+	if len(filter.BrandIDs) > 0 && !containsInt(filter.BrandIDs, car.BrandID) {
+		return false
+	}
+	if len(filter.Cities) > 0 {
+		found := false
+		for _, city := range filter.Cities {
+			if strings.EqualFold(car.City, city) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.YearFrom != nil && car.Year < *filter.YearFrom {
+		return false
+	}
+	if filter.YearTo != nil && car.Year > *filter.YearTo {
+		return false
+	}
+	if filter.PriceFrom != nil && car.Price < *filter.PriceFrom {
+		return false
+	}
+	if filter.PriceTo != nil && car.Price > *filter.PriceTo {
+		return false
+	}
+	return true
+}
+
+// compareCars сравнивает машины по ключам сортировки, при равенстве по id, как ORDER BY в postgres
+func compareCars(a, b *models.Car, keys []models.SortKey) int {
+	for _, key := range keys {
+		cmp := 0
+		switch key.Field {
+		case "id":
+			cmp = compareInts(a.ID, b.ID)
+		case "model":
+			cmp = strings.Compare(a.Model, b.Model)
+		case "brand_id":
+			cmp = compareInts(a.BrandID, b.BrandID)
+		case "city":
+			cmp = strings.Compare(a.City, b.City)
+		case "year":
+			cmp = compareInts(a.Year, b.Year)
+		case "price":
+			cmp = compareInts(a.Price, b.Price)
+		}
+		if key.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return compareInts(a.ID, b.ID)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
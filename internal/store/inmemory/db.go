@@ -0,0 +1,121 @@
+package inmemory
+
+import (
+	"fmt"
+	"project/internal/models"
+	"project/internal/store"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DB хранит все данные в памяти процесса. Используется для тестов и локальной разработки
+type DB struct {
+	mu sync.RWMutex
+
+	brandsData  map[int]*models.Brand
+	carsData    map[int]*models.Car
+	usersData   map[int]*models.User
+	favourites  map[int]map[int]time.Time // user_id -> car_id -> время добавления
+	lastBrandID int
+	lastCarID   int
+	lastUserID  int
+
+	sessionsData  map[int]*models.Session
+	refreshTokens map[string]*models.RefreshToken // token_hash -> токен
+	lastSessionID int
+	revokedTokens map[string]time.Time // jti -> истечение токена
+
+	passwordResets map[string]*models.PasswordReset // token_hash -> токен
+	loginAttempts  map[string]*models.LoginAttempt
+	auditLog       []*models.AuditEvent
+	apiKeysData    map[int]*models.APIKey
+	lastAPIKeyID   int
+	twoFactorData  map[int]*models.TwoFactor
+	recoveryCodes  map[int][]string
+	identitiesData []*models.Identity
+
+	brands     store.BrandsRepository
+	cars       store.CarsRepository
+	users      store.UsersRepository
+	sessions   store.SessionsRepository
+	revoked    store.RevokedTokensRepository
+	resets     store.PasswordResetsRepository
+	attempts   store.LoginAttemptsRepository
+	audit      store.AuditRepository
+	apiKeys    store.APIKeysRepository
+	twoFactor  store.TwoFactorRepository
+	identities store.IdentitiesRepository
+}
+
+// NewDB создает все репозитории заранее, поэтому аксессоры безопасно вызывать из разных горутин
+func NewDB() store.Store {
+	db := &DB{
+		brandsData: make(map[int]*models.Brand),
+		carsData:   make(map[int]*models.Car),
+		usersData:  make(map[int]*models.User),
+		favourites: make(map[int]map[int]time.Time),
+
+		sessionsData:  make(map[int]*models.Session),
+		refreshTokens: make(map[string]*models.RefreshToken),
+		revokedTokens: make(map[string]time.Time),
+
+		passwordResets: make(map[string]*models.PasswordReset),
+		loginAttempts:  make(map[string]*models.LoginAttempt),
+		apiKeysData:    make(map[int]*models.APIKey),
+		twoFactorData:  make(map[int]*models.TwoFactor),
+		recoveryCodes:  make(map[int][]string),
+	}
+	db.brands = &BrandsRepository{db: db}
+	db.cars = &CarsRepository{db: db}
+	db.users = &UsersRepository{db: db}
+	db.sessions = &SessionsRepository{db: db}
+	db.revoked = &RevokedTokensRepository{db: db}
+	db.resets = &PasswordResetsRepository{db: db}
+	db.attempts = &LoginAttemptsRepository{db: db}
+	db.audit = &AuditRepository{db: db}
+	db.apiKeys = &APIKeysRepository{db: db}
+	db.twoFactor = &TwoFactorRepository{db: db}
+	db.identities = &IdentitiesRepository{db: db}
+	return db
+}
+
+func (db *DB) Connect(url string) error {
+	return nil
+}
+
+func (db *DB) Close() error {
+	return nil
+}
+
+// ilike повторяет поведение postgres ILIKE '%substr%'
+func ilike(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// limit оставляет limit+1 элементов, как LIMIT в postgres репозиториях
+func limit[T any](items []T, n int) []T {
+	if n > 0 && len(items) > n+1 {
+		return items[:n+1]
+	}
+	return items
+}
+
+// deleteCar удаляет машину вместе с избранным, как ON DELETE CASCADE. Вызывается под mu.Lock
+func (db *DB) deleteCar(id int) {
+	delete(db.carsData, id)
+	for _, favourites := range db.favourites {
+		delete(favourites, id)
+	}
+}
+
+// checkCarReferences повторяет внешние ключи cars.brand_id и cars.user_id. Вызывается под mu.Lock
+func (db *DB) checkCarReferences(car *models.Car) error {
+	if _, ok := db.brandsData[car.BrandID]; !ok {
+		return fmt.Errorf("%w: brand %d", store.ErrInvalidReference, car.BrandID)
+	}
+	if _, ok := db.usersData[car.UserId]; !ok {
+		return fmt.Errorf("%w: user %d", store.ErrInvalidReference, car.UserId)
+	}
+	return nil
+}
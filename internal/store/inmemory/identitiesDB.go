@@ -0,0 +1,48 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) Identities() store.IdentitiesRepository {
+	return db.identities
+}
+
+type IdentitiesRepository struct {
+	db *DB
+}
+
+func (i IdentitiesRepository) ByExternalID(ctx context.Context, issuer, subject string) (*models.Identity, error) {
+	i.db.mu.RLock()
+	defer i.db.mu.RUnlock()
+
+	for _, identity := range i.db.identitiesData {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			copied := *identity
+			return &copied, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (i IdentitiesRepository) Create(ctx context.Context, identity *models.Identity) error {
+	i.db.mu.Lock()
+	defer i.db.mu.Unlock()
+
+	if _, ok := i.db.usersData[identity.UserID]; !ok {
+		return fmt.Errorf("%w: user %d", store.ErrInvalidReference, identity.UserID)
+	}
+	for _, stored := range i.db.identitiesData {
+		if stored.Issuer == identity.Issuer && stored.Subject == identity.Subject {
+			return fmt.Errorf("%w: user_identities_pkey", store.ErrConflict)
+		}
+	}
+	identity.CreatedAt = time.Now()
+	copied := *identity
+	i.db.identitiesData = append(i.db.identitiesData, &copied)
+	return nil
+}
@@ -0,0 +1,71 @@
+package inmemory
+
+import (
+	"context"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) LoginAttempts() store.LoginAttemptsRepository {
+	return db.attempts
+}
+
+type LoginAttemptsRepository struct {
+	db *DB
+}
+
+func (l LoginAttemptsRepository) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	l.db.mu.RLock()
+	defer l.db.mu.RUnlock()
+
+	attempt := &models.LoginAttempt{Key: key}
+	if stored, ok := l.db.loginAttempts[key]; ok {
+		*attempt = *stored
+		if stored.LockedUntil != nil {
+			lockedUntil := *stored.LockedUntil
+			attempt.LockedUntil = &lockedUntil
+		}
+	}
+	return attempt, nil
+}
+
+func (l LoginAttemptsRepository) Update(ctx context.Context, key string, fn func(attempt *models.LoginAttempt)) error {
+	l.db.mu.Lock()
+	defer l.db.mu.Unlock()
+
+	attempt := &models.LoginAttempt{Key: key}
+	if stored, ok := l.db.loginAttempts[key]; ok {
+		*attempt = *stored
+	}
+	fn(attempt)
+	if attempt.Empty() {
+		delete(l.db.loginAttempts, key)
+		return nil
+	}
+	if attempt.LockedUntil != nil {
+		lockedUntil := *attempt.LockedUntil
+		attempt.LockedUntil = &lockedUntil
+	}
+	l.db.loginAttempts[key] = attempt
+	return nil
+}
+
+func (db *DB) Audit() store.AuditRepository {
+	return db.audit
+}
+
+type AuditRepository struct {
+	db *DB
+}
+
+func (a AuditRepository) Record(ctx context.Context, event *models.AuditEvent) error {
+	a.db.mu.Lock()
+	defer a.db.mu.Unlock()
+
+	event.ID = len(a.db.auditLog) + 1
+	event.CreatedAt = time.Now()
+	stored := *event
+	a.db.auditLog = append(a.db.auditLog, &stored)
+	return nil
+}
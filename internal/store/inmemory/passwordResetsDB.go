@@ -0,0 +1,59 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) PasswordResets() store.PasswordResetsRepository {
+	return db.resets
+}
+
+type PasswordResetsRepository struct {
+	db *DB
+}
+
+func (p PasswordResetsRepository) Create(ctx context.Context, reset *models.PasswordReset, since time.Time) error {
+	p.db.mu.Lock()
+	defer p.db.mu.Unlock()
+
+	if _, ok := p.db.usersData[reset.UserID]; !ok {
+		return fmt.Errorf("%w: user %d", store.ErrInvalidReference, reset.UserID)
+	}
+	if _, ok := p.db.passwordResets[reset.TokenHash]; ok {
+		return fmt.Errorf("%w: password_resets_pkey", store.ErrConflict)
+	}
+	for _, stored := range p.db.passwordResets {
+		if stored.UserID == reset.UserID && stored.CreatedAt.After(since) {
+			return fmt.Errorf("%w: password reset issued recently", store.ErrConflict)
+		}
+	}
+	now := time.Now()
+	for _, stored := range p.db.passwordResets {
+		if stored.UserID == reset.UserID && stored.UsedAt == nil {
+			usedAt := now
+			stored.UsedAt = &usedAt
+		}
+	}
+	reset.CreatedAt = now
+	stored := *reset
+	p.db.passwordResets[reset.TokenHash] = &stored
+	return nil
+}
+
+func (p PasswordResetsRepository) Consume(ctx context.Context, tokenHash string, at time.Time) (*models.PasswordReset, error) {
+	p.db.mu.Lock()
+	defer p.db.mu.Unlock()
+
+	stored, ok := p.db.passwordResets[tokenHash]
+	if !ok || stored.UsedAt != nil || !at.Before(stored.ExpiresAt) {
+		return nil, store.ErrNotFound
+	}
+	usedAt := at
+	stored.UsedAt = &usedAt
+	reset := *stored
+	return &reset, nil
+}
@@ -0,0 +1,37 @@
+package inmemory
+
+import (
+	"context"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) RevokedTokens() store.RevokedTokensRepository {
+	return db.revoked
+}
+
+type RevokedTokensRepository struct {
+	db *DB
+}
+
+func (r RevokedTokensRepository) Add(ctx context.Context, jti string, expiresAt time.Time) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	now := time.Now()
+	for stored, exp := range r.db.revokedTokens {
+		if exp.Before(now) {
+			delete(r.db.revokedTokens, stored)
+		}
+	}
+	r.db.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+func (r RevokedTokensRepository) Contains(ctx context.Context, jti string) (bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	_, ok := r.db.revokedTokens[jti]
+	return ok, nil
+}
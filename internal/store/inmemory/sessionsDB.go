@@ -0,0 +1,150 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"project/internal/models"
+	"project/internal/store"
+	"sort"
+	"time"
+)
+
+func (db *DB) Sessions() store.SessionsRepository {
+	return db.sessions
+}
+
+type SessionsRepository struct {
+	db *DB
+}
+
+func (s SessionsRepository) Create(ctx context.Context, session *models.Session, token *models.RefreshToken) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.usersData[session.UserID]; !ok {
+		return fmt.Errorf("%w: user %d", store.ErrInvalidReference, session.UserID)
+	}
+	if _, ok := s.db.refreshTokens[token.Hash]; ok {
+		return fmt.Errorf("%w: refresh_tokens_pkey", store.ErrConflict)
+	}
+	now := time.Now()
+	s.db.lastSessionID++
+	session.ID = s.db.lastSessionID
+	session.CreatedAt, session.LastUsedAt = now, now
+	s.db.sessionsData[session.ID] = copySession(session)
+
+	token.SessionID, token.CreatedAt = session.ID, now
+	stored := *token
+	s.db.refreshTokens[token.Hash] = &stored
+	return nil
+}
+
+func (s SessionsRepository) Rotate(ctx context.Context, tokenHash string, next *models.RefreshToken, accessTokenID string) (*models.Session, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	token, ok := s.db.refreshTokens[tokenHash]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	session, ok := s.db.sessionsData[token.SessionID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	if token.UsedAt != nil {
+		if session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+		return copySession(session), store.ErrTokenReused
+	}
+	if !now.Before(token.ExpiresAt) || !session.Active(now) {
+		return nil, store.ErrNotFound
+	}
+	if _, ok := s.db.refreshTokens[next.Hash]; ok {
+		return nil, fmt.Errorf("%w: refresh_tokens_pkey", store.ErrConflict)
+	}
+
+	token.UsedAt = &now
+	next.SessionID, next.CreatedAt = session.ID, now
+	stored := *next
+	s.db.refreshTokens[next.Hash] = &stored
+	session.LastUsedAt, session.ExpiresAt, session.AccessTokenID = now, next.ExpiresAt, accessTokenID
+	return copySession(session), nil
+}
+
+func (s SessionsRepository) ByID(ctx context.Context, id int) (*models.Session, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	session, ok := s.db.sessionsData[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copySession(session), nil
+}
+
+func (s SessionsRepository) ActiveOfUser(ctx context.Context, userID int) ([]*models.Session, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	now := time.Now()
+	sessions := make([]*models.Session, 0)
+	for _, session := range s.db.sessionsData {
+		if session.UserID == userID && session.Active(now) {
+			sessions = append(sessions, copySession(session))
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+	return sessions, nil
+}
+
+func (s SessionsRepository) Revoke(ctx context.Context, id int, at time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	session, ok := s.db.sessionsData[id]
+	if !ok || session.RevokedAt != nil {
+		return store.ErrNotFound
+	}
+	session.RevokedAt = &at
+	return nil
+}
+
+func (s SessionsRepository) RevokeAllOfUser(ctx context.Context, userID int, at time.Time) ([]*models.Session, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	revoked := make([]*models.Session, 0)
+	for _, session := range s.db.sessionsData {
+		if session.UserID == userID && session.Active(at) {
+			revokedAt := at
+			session.RevokedAt = &revokedAt
+			revoked = append(revoked, copySession(session))
+		}
+	}
+	return revoked, nil
+}
+
+// deleteSessionsOf удаляет сессии пользователя вместе с токенами. Вызывается под mu.Lock
+func (db *DB) deleteSessionsOf(userID int) {
+	for id, session := range db.sessionsData {
+		if session.UserID == userID {
+			delete(db.sessionsData, id)
+		}
+	}
+	for hash, token := range db.refreshTokens {
+		if _, ok := db.sessionsData[token.SessionID]; !ok {
+			delete(db.refreshTokens, hash)
+		}
+	}
+}
+
+func copySession(session *models.Session) *models.Session {
+	s := *session
+	if session.RevokedAt != nil {
+		revokedAt := *session.RevokedAt
+		s.RevokedAt = &revokedAt
+	}
+	return &s
+}
@@ -0,0 +1,117 @@
+package inmemory
+
+import (
+	"context"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) TwoFactor() store.TwoFactorRepository {
+	return db.twoFactor
+}
+
+type TwoFactorRepository struct {
+	db *DB
+}
+
+func (t TwoFactorRepository) ByUser(ctx context.Context, userID int) (*models.TwoFactor, error) {
+	t.db.mu.RLock()
+	defer t.db.mu.RUnlock()
+
+	twoFactor, ok := t.db.twoFactorData[userID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copied := *twoFactor
+	return &copied, nil
+}
+
+func (t TwoFactorRepository) Begin(ctx context.Context, twoFactor *models.TwoFactor) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if stored, ok := t.db.twoFactorData[twoFactor.UserID]; ok && stored.Enabled() {
+		return store.ErrConflict
+	}
+	t.db.twoFactorData[twoFactor.UserID] = &models.TwoFactor{
+		UserID:    twoFactor.UserID,
+		Secret:    twoFactor.Secret,
+		CreatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (t TwoFactorRepository) Enable(ctx context.Context, userID int, step int64, at time.Time, codeHashes []string) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	twoFactor, ok := t.db.twoFactorData[userID]
+	if !ok || twoFactor.Enabled() || twoFactor.LastStep >= step {
+		return store.ErrNotFound
+	}
+	twoFactor.EnabledAt = &at
+	twoFactor.LastStep = step
+	t.db.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+func (t TwoFactorRepository) UseStep(ctx context.Context, userID int, step int64) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	twoFactor, ok := t.db.twoFactorData[userID]
+	if !ok || !twoFactor.Enabled() || twoFactor.LastStep >= step {
+		return store.ErrNotFound
+	}
+	twoFactor.LastStep = step
+	return nil
+}
+
+func (t TwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	t.db.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+func (t TwoFactorRepository) UseRecoveryCode(ctx context.Context, userID int, codeHash string, at time.Time) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	for _, hash := range t.db.recoveryCodes[userID] {
+		if hash == codeHash {
+			t.db.deleteRecoveryCode(userID, codeHash)
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (t TwoFactorRepository) Disable(ctx context.Context, userID int) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if _, ok := t.db.twoFactorData[userID]; !ok {
+		return store.ErrNotFound
+	}
+	delete(t.db.twoFactorData, userID)
+	delete(t.db.recoveryCodes, userID)
+	return nil
+}
+
+// replaceRecoveryCodes хранит только неиспользованные коды, использованный код удаляется
+func (db *DB) replaceRecoveryCodes(userID int, codeHashes []string) {
+	db.recoveryCodes[userID] = append([]string(nil), codeHashes...)
+}
+
+func (db *DB) deleteRecoveryCode(userID int, codeHash string) {
+	codes := db.recoveryCodes[userID]
+	for i, hash := range codes {
+		if hash == codeHash {
+			db.recoveryCodes[userID] = append(codes[:i:i], codes[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"project/internal/models"
+	"project/internal/store"
+	"sort"
+	"time"
+)
+
+func (db *DB) Users() store.UsersRepository {
+	return db.users
+}
+
+type UsersRepository struct {
+	db *DB
+}
+
+func (u UsersRepository) Create(ctx context.Context, user *models.User) error {
+	if err := user.Validate(); err != nil {
+		return err
+	}
+	if err := user.BeforeCreating(); err != nil {
+		return err
+	}
+
+	u.db.mu.Lock()
+	defer u.db.mu.Unlock()
+
+	for _, stored := range u.db.usersData {
+		if stored.Email == user.Email {
+			return fmt.Errorf("%w: users_email_key", store.ErrConflict)
+		}
+	}
+	u.db.lastUserID++
+	user.ID = u.db.lastUserID
+	stored := copyUser(user)
+	stored.VerifiedAt, stored.VerificationSentAt = nil, nil
+	if stored.Role == nil {
+		role := models.Client
+		stored.Role = &role
+	}
+	u.db.usersData[user.ID] = stored
+	return nil
+}
+
+func (u UsersRepository) All(ctx context.Context, filter *models.UserFilter) (*models.Page[*models.User], error) {
+	u.db.mu.RLock()
+	defer u.db.mu.RUnlock()
+
+	users := make([]*models.User, 0, len(u.db.usersData))
+	for _, user := range u.db.usersData {
+		if filter.After != nil && user.ID <= filter.After.ID {
+			continue
+		}
+		users = append(users, copyUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return models.NewPage(limit(users, filter.Limit), filter.Limit, func(user *models.User) *models.Cursor {
+		return &models.Cursor{ID: user.ID}
+	}), nil
+}
+
+func (u UsersRepository) ByID(ctx context.Context, id int) (*models.User, error) {
+	u.db.mu.RLock()
+	defer u.db.mu.RUnlock()
+
+	user, ok := u.db.usersData[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (u UsersRepository) ByEmail(ctx context.Context, email string) (*models.User, error) {
+	u.db.mu.RLock()
+	defer u.db.mu.RUnlock()
+
+	for _, user := range u.db.usersData {
+		if user.Email == email {
+			return copyUser(user), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (u UsersRepository) Update(ctx context.Context, user *models.User) error {
+	if err := user.Validate(); err != nil {
+		return err
+	}
+	if err := user.BeforeCreating(); err != nil {
+		return err
+	}
+
+	u.db.mu.Lock()
+	defer u.db.mu.Unlock()
+
+	stored, ok := u.db.usersData[user.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	stored.Name = user.Name
+	stored.Surname = user.Surname
+	stored.PhoneNumber = user.PhoneNumber
+	stored.BirthDate = user.BirthDate
+	if user.EncryptedPassword != "" {
+		stored.EncryptedPassword = user.EncryptedPassword
+	}
+	if user.Role != nil {
+		role := *user.Role
+		stored.Role = &role
+	}
+	return nil
+}
+
+func (u UsersRepository) UpdatePassword(ctx context.Context, user *models.User) error {
+	u.db.mu.Lock()
+	defer u.db.mu.Unlock()
+
+	stored, ok := u.db.usersData[user.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	stored.EncryptedPassword = user.EncryptedPassword
+	return nil
+}
+
+func (u UsersRepository) UpdateRole(ctx context.Context, id int, role models.Role) error {
+	u.db.mu.Lock()
+	defer u.db.mu.Unlock()
+
+	stored, ok := u.db.usersData[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	stored.Role = &role
+	return nil
+}
+
+func (u UsersRepository) MarkVerified(ctx context.Context, id int, at time.Time) error {
+	u.db.mu.Lock()
+	defer u.db.mu.Unlock()
+
+	stored, ok := u.db.usersData[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if stored.VerifiedAt == nil {
+		stored.VerifiedAt = &at
+	}
+	return nil
+}
+
+func (u UsersRepository) MarkVerificationSent(ctx context.Context, id int, at, since time.Time) error {
+	u.db.mu.Lock()
+	defer u.db.mu.Unlock()
+
+	stored, ok := u.db.usersData[id]
+	if !ok || stored.VerifiedAt != nil || (stored.VerificationSentAt != nil && !stored.VerificationSentAt.Before(since)) {
+		return store.ErrNotFound
+	}
+	stored.VerificationSentAt = &at
+	return nil
+}
+
+func (u UsersRepository) Delete(ctx context.Context, id int) ([]*models.Car, error) {
+	u.db.mu.Lock()
+	defer u.db.mu.Unlock()
+
+	if _, ok := u.db.usersData[id]; !ok {
+		return nil, store.ErrNotFound
+	}
+	delete(u.db.usersData, id)
+	delete(u.db.favourites, id)
+	u.db.deleteSessionsOf(id)
+	delete(u.db.twoFactorData, id)
+	delete(u.db.recoveryCodes, id)
+	identities := u.db.identitiesData[:0]
+	for _, identity := range u.db.identitiesData {
+		if identity.UserID != id {
+			identities = append(identities, identity)
+		}
+	}
+	u.db.identitiesData = identities
+	for keyID, key := range u.db.apiKeysData {
+		if key.UserID == id {
+			delete(u.db.apiKeysData, keyID)
+		}
+	}
+	for hash, reset := range u.db.passwordResets {
+		if reset.UserID == id {
+			delete(u.db.passwordResets, hash)
+		}
+	}
+	cars := make([]*models.Car, 0)
+	for carID, car := range u.db.carsData {
+		if car.UserId == id {
+			cars = append(cars, car)
+			u.db.deleteCar(carID)
+		}
+	}
+	return cars, nil
+}
+
+func copyUser(user *models.User) *models.User {
+	u := *user
+	if user.Role != nil {
+		role := *user.Role
+		u.Role = &role
+	}
+	if user.VerifiedAt != nil {
+		verifiedAt := *user.VerifiedAt
+		u.VerifiedAt = &verifiedAt
+	}
+	if user.VerificationSentAt != nil {
+		sentAt := *user.VerificationSentAt
+		u.VerificationSentAt = &sentAt
+	}
+	return &u
+}
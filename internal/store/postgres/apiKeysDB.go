@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) APIKeys() store.APIKeysRepository {
+	return db.apiKeys
+}
+
+type APIKeysRepository struct {
+	conn *sqlx.DB
+}
+
+func newAPIKeysRepository(conn *sqlx.DB) store.APIKeysRepository {
+	return &APIKeysRepository{conn: conn}
+}
+
+func (a APIKeysRepository) Create(ctx context.Context, key *models.APIKey) error {
+	err := a.conn.GetContext(ctx, key, "INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4, $5) RETURNING *",
+		key.UserID, key.Name, key.Prefix, key.KeyHash, key.Scopes)
+	return translateError(err)
+}
+
+func (a APIKeysRepository) ByID(ctx context.Context, id int) (*models.APIKey, error) {
+	key := new(models.APIKey)
+	if err := a.conn.GetContext(ctx, key, "SELECT * FROM api_keys WHERE id = $1", id); err != nil {
+		return nil, translateError(err)
+	}
+	return key, nil
+}
+
+func (a APIKeysRepository) ByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	key := new(models.APIKey)
+	if err := a.conn.GetContext(ctx, key, "SELECT * FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL", keyHash); err != nil {
+		return nil, translateError(err)
+	}
+	return key, nil
+}
+
+func (a APIKeysRepository) AllOfUser(ctx context.Context, userID int) ([]*models.APIKey, error) {
+	keys := make([]*models.APIKey, 0)
+	if err := a.conn.SelectContext(ctx, &keys, "SELECT * FROM api_keys WHERE user_id = $1 ORDER BY id", userID); err != nil {
+		return nil, translateError(err)
+	}
+	return keys, nil
+}
+
+func (a APIKeysRepository) Revoke(ctx context.Context, id int, at time.Time) error {
+	return affectOne(a.conn.ExecContext(ctx, "UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", at, id))
+}
+
+func (a APIKeysRepository) Touch(ctx context.Context, id int, at time.Time) error {
+	return affectOne(a.conn.ExecContext(ctx, "UPDATE api_keys SET last_used_at = $1 WHERE id = $2", at, id))
+}
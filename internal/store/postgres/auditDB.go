@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"project/internal/models"
+	"project/internal/store"
+)
+
+func (db *DB) Audit() store.AuditRepository {
+	return db.audit
+}
+
+type AuditRepository struct {
+	conn *sqlx.DB
+}
+
+func newAuditRepository(conn *sqlx.DB) store.AuditRepository {
+	return &AuditRepository{conn: conn}
+}
+
+func (a AuditRepository) Record(ctx context.Context, event *models.AuditEvent) error {
+	err := a.conn.GetContext(ctx, event, "INSERT INTO audit_log (event, subject, ip, actor_id) VALUES ($1, $2, $3, $4) RETURNING *",
+		event.Event, event.Subject, event.IP, event.ActorID)
+	return translateError(err)
+}
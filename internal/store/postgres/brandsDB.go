@@ -2,16 +2,12 @@ package postgres
 
 import (
 	"context"
-	"fmt"
 	"github.com/jmoiron/sqlx"
 	"project/internal/models"
 	"project/internal/store"
 )
 
 func (db *DB) Brands() store.BrandsRepository {
-	if db.brands == nil {
-		db.brands = newBrandsRepository(db.conn)
-	}
 	return db.brands
 }
 
@@ -24,52 +20,76 @@ func newBrandsRepository(conn *sqlx.DB) store.BrandsRepository {
 }
 
 func (c BrandsRepository) Create(ctx context.Context, brand *models.Brand) error {
-	_, err := c.conn.Exec("INSERT INTO brands(name) VALUES ($1)", brand.Name)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeAndNotify(ctx, c.conn, func(tx *sqlx.Tx) (*store.Change, error) {
+		if err := tx.GetContext(ctx, &brand.ID, "INSERT INTO brands(name) VALUES ($1) RETURNING id", brand.Name); err != nil {
+			return nil, translateError(err)
+		}
+		return brandChange(brand.ID), nil
+	})
+}
+
+// Upsert вставляет бренд с заданным id или перезаписывает существующий
+func (c BrandsRepository) Upsert(ctx context.Context, brand *models.Brand) error {
+	return writeAndNotify(ctx, c.conn, func(tx *sqlx.Tx) (*store.Change, error) {
+		_, err := tx.ExecContext(ctx, "INSERT INTO brands(id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name",
+			brand.ID, brand.Name)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		// явные id не двигают serial, поэтому подтягиваем последовательность
+		if _, err := tx.ExecContext(ctx, "SELECT setval(pg_get_serial_sequence('brands', 'id'), (SELECT MAX(id) FROM brands))"); err != nil {
+			return nil, translateError(err)
+		}
+		return brandChange(brand.ID), nil
+	})
 }
 
-func (c BrandsRepository) All(ctx context.Context, filter *models.BrandFilter) ([]*models.Brand, error) {
+func (c BrandsRepository) All(ctx context.Context, filter *models.BrandFilter) (*models.Page[*models.Brand], error) {
 	brands := make([]*models.Brand, 0)
-	basicQuery := "SELECT * FROM brands"
+	q := new(queryBuilder)
 
 	if filter.Query != nil {
-		basicQuery = fmt.Sprintf("%s WHERE name ILIKE $1", basicQuery)
-
-		if err := c.conn.Select(&brands, basicQuery, "%"+*filter.Query+"%"); err != nil {
-			return nil, err
-		}
-		return brands, nil
+		q.where("name ILIKE " + q.arg("%"+*filter.Query+"%"))
+	}
+	if filter.After != nil {
+		q.where("id > " + q.arg(filter.After.ID))
 	}
 
-	if err := c.conn.Select(&brands, basicQuery); err != nil {
-		return nil, err
+	query := "SELECT * FROM brands" + q.whereClause() + " ORDER BY id" + q.limit(filter.Limit)
+	if err := c.conn.Select(&brands, query, q.values...); err != nil {
+		return nil, translateError(err)
 	}
-	return brands, nil
+	return models.NewPage(brands, filter.Limit, func(brand *models.Brand) *models.Cursor {
+		return &models.Cursor{ID: brand.ID}
+	}), nil
 }
 
 func (c BrandsRepository) ByID(ctx context.Context, id int) (*models.Brand, error) {
 	brand := new(models.Brand)
 	if err := c.conn.Get(brand, "SELECT id, name FROM brands WHERE id = $1", id); err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	return brand, nil
 }
 
 func (c BrandsRepository) Update(ctx context.Context, brand *models.Brand) error {
-	_, err := c.conn.Exec("UPDATE brands SET name = $1 WHERE id = $2", brand.Name, brand.ID)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeAndNotify(ctx, c.conn, func(tx *sqlx.Tx) (*store.Change, error) {
+		if err := affectOne(tx.ExecContext(ctx, "UPDATE brands SET name = $1 WHERE id = $2", brand.Name, brand.ID)); err != nil {
+			return nil, err
+		}
+		return brandChange(brand.ID), nil
+	})
 }
 
 func (c BrandsRepository) Delete(ctx context.Context, id int) error {
-	_, err := c.conn.Exec("DELETE FROM brands WHERE id = $1", id)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeAndNotify(ctx, c.conn, func(tx *sqlx.Tx) (*store.Change, error) {
+		if err := affectOne(tx.ExecContext(ctx, "DELETE FROM brands WHERE id = $1", id)); err != nil {
+			return nil, err
+		}
+		return brandChange(id), nil
+	})
+}
+
+func brandChange(id int) *store.Change {
+	return &store.Change{Entity: store.ChangeBrand, ID: id}
 }
@@ -2,16 +2,15 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"project/internal/models"
 	"project/internal/store"
+	"strings"
 )
 
 func (db *DB) Cars() store.CarsRepository {
-	if db.cars == nil {
-		db.cars = newCarsRepository(db.conn)
-	}
 	return db.cars
 }
 
@@ -24,38 +23,90 @@ func newCarsRepository(conn *sqlx.DB) store.CarsRepository {
 }
 
 func (c CarsRepository) Create(ctx context.Context, car *models.Car) error {
-	_, err := c.conn.Exec("INSERT INTO cars (model, user_id, brand_id, city, year, price, description) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-		car.Model, car.UserId, car.BrandID, car.City, car.Year, car.Price, car.Description)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeAndNotify(ctx, c.conn, func(tx *sqlx.Tx) (*store.Change, error) {
+		err := tx.GetContext(ctx, &car.ID, "INSERT INTO cars (model, user_id, brand_id, city, year, price, description) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+			car.Model, car.UserId, car.BrandID, car.City, car.Year, car.Price, car.Description)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return carChange(car.ID, car.City), nil
+	})
+}
+
+// Upsert вставляет объявление с заданным id или перезаписывает существующее
+func (c CarsRepository) Upsert(ctx context.Context, car *models.Car) error {
+	return writeAndNotify(ctx, c.conn, func(tx *sqlx.Tx) (*store.Change, error) {
+		oldCity, err := lockCarCity(ctx, tx, car.ID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO cars (id, model, user_id, brand_id, city, year, price, description) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (id) DO UPDATE SET model = EXCLUDED.model, user_id = EXCLUDED.user_id, brand_id = EXCLUDED.brand_id,
+			city = EXCLUDED.city, year = EXCLUDED.year, price = EXCLUDED.price, description = EXCLUDED.description`,
+			car.ID, car.Model, car.UserId, car.BrandID, car.City, car.Year, car.Price, car.Description)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT setval(pg_get_serial_sequence('cars', 'id'), (SELECT MAX(id) FROM cars))"); err != nil {
+			return nil, translateError(err)
+		}
+		return carChange(car.ID, oldCity, car.City), nil
+	})
 }
 
-func (c CarsRepository) All(ctx context.Context, filter *models.CarFilter) ([]*models.Car, error) {
+func (c CarsRepository) All(ctx context.Context, filter *models.CarFilter) (*models.Page[*models.Car], error) {
 	cars := make([]*models.Car, 0)
-	basicQuery := "SELECT * FROM cars"
+	q := new(queryBuilder)
 
 	if filter.Query != nil {
-		basicQuery = fmt.Sprintf("%s WHERE model ILIKE $1", basicQuery)
+		p := q.arg("%" + *filter.Query + "%")
+		q.where(fmt.Sprintf("(model ILIKE %s OR description ILIKE %s)", p, p))
+	}
+	if len(filter.BrandIDs) > 0 {
+		q.where(fmt.Sprintf("brand_id IN (%s)", q.args(filter.BrandIDs)))
+	}
+	if len(filter.Cities) > 0 {
+		cities := make([]string, 0, len(filter.Cities))
+		for _, city := range filter.Cities {
+			cities = append(cities, strings.ToLower(city))
+		}
+		q.where(fmt.Sprintf("LOWER(city) IN (%s)", q.args(cities)))
+	}
+	if filter.YearFrom != nil {
+		q.where("year >= " + q.arg(*filter.YearFrom))
+	}
+	if filter.YearTo != nil {
+		q.where("year <= " + q.arg(*filter.YearTo))
+	}
+	if filter.PriceFrom != nil {
+		q.where("price >= " + q.arg(*filter.PriceFrom))
+	}
+	if filter.PriceTo != nil {
+		q.where("price <= " + q.arg(*filter.PriceTo))
+	}
 
-		if err := c.conn.Select(&cars, basicQuery, "%"+*filter.Query+"%"); err != nil {
+	if filter.After != nil {
+		after, err := models.CursorCar(filter.After, filter.Sort)
+		if err != nil {
 			return nil, err
 		}
-		return cars, nil
+		q.where(keysetCondition(q, filter.Sort, after))
 	}
 
-	if err := c.conn.Select(&cars, basicQuery); err != nil {
-		return nil, err
+	query := "SELECT * FROM cars" + q.whereClause() + orderBy(filter.Sort) + q.limit(filter.Limit)
+	if err := c.conn.Select(&cars, query, q.values...); err != nil {
+		return nil, translateError(err)
 	}
-	return cars, nil
+	return models.NewPage(cars, filter.Limit, func(car *models.Car) *models.Cursor {
+		return models.NewCarCursor(car, filter.Sort)
+	}), nil
 }
 
 func (c CarsRepository) AllOfUser(ctx context.Context, userId int) ([]*models.Car, error) {
 	cars := make([]*models.Car, 0)
 
-	if err := c.conn.Select(&cars, "SELECT * FROM cars WHERE user_id = $1", userId); err != nil {
-		return nil, err
+	if err := c.conn.Select(&cars, "SELECT * FROM cars WHERE user_id = $1 ORDER BY id", userId); err != nil {
+		return nil, translateError(err)
 	}
 	return cars, nil
 }
@@ -63,89 +114,119 @@ func (c CarsRepository) AllOfUser(ctx context.Context, userId int) ([]*models.Ca
 func (c CarsRepository) ByID(ctx context.Context, id int) (*models.Car, error) {
 	car := new(models.Car)
 	if err := c.conn.Get(car, "SELECT * FROM cars WHERE id = $1", id); err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	return car, nil
 }
 
 func (c CarsRepository) Update(ctx context.Context, car *models.Car) error {
-	_, err := c.conn.Exec("UPDATE cars SET city = $1 WHERE id = $2", car.City, car.ID)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (c CarsRepository) Delete(ctx context.Context, id int) error {
-	_, err := c.conn.Exec("DELETE FROM cars WHERE id = $1", id)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (c CarsRepository) Sort(ctx context.Context, sortType string) ([]*models.Car, error) {
-	sortedCars := make([]*models.Car, 0)
-	if sortType == "model-asc" {
-		if err := c.conn.Select(&sortedCars, "SELECT * FROM cars ORDER BY model;"); err != nil {
+	return writeAndNotify(ctx, c.conn, func(tx *sqlx.Tx) (*store.Change, error) {
+		oldCity, err := lockCarCity(ctx, tx, car.ID)
+		if err != nil {
 			return nil, err
 		}
-	}
-	if sortType == "price-asc" {
-		if err := c.conn.Select(&sortedCars, "SELECT * FROM cars ORDER BY price;"); err != nil {
+		err = affectOne(tx.ExecContext(ctx, "UPDATE cars SET model = $1, brand_id = $2, city = $3, year = $4, price = $5, description = $6 WHERE id = $7",
+			car.Model, car.BrandID, car.City, car.Year, car.Price, car.Description, car.ID))
+		if err != nil {
 			return nil, err
 		}
-	}
-	return sortedCars, nil
+		return carChange(car.ID, oldCity, car.City), nil
+	})
 }
 
-func (c CarsRepository) FilterByCity(ctx context.Context, filter string) ([]*models.Car, error) {
-	filteredCars := make([]*models.Car, 0)
-	if err := c.conn.Select(&filteredCars, "SELECT * FROM cars WHERE city ILIKE $1", ""+filter+""); err != nil {
-		return nil, err
-	}
-	return filteredCars, nil
+func (c CarsRepository) Delete(ctx context.Context, id int) error {
+	return writeAndNotify(ctx, c.conn, func(tx *sqlx.Tx) (*store.Change, error) {
+		var city string
+		if err := tx.GetContext(ctx, &city, "DELETE FROM cars WHERE id = $1 RETURNING city", id); err != nil {
+			return nil, translateError(err)
+		}
+		return carChange(id, city), nil
+	})
 }
 
-func (c CarsRepository) AddToFav(ctx context.Context, filter *models.CarFilter) error {
-	favouriteCar := new(models.Car)
-	basicQuery := "SELECT * FROM cars WHERE id = $1"
-
-	if filter.CarId != nil {
-		if err := c.conn.Get(favouriteCar, basicQuery, filter.CarId); err != nil {
-			return err
-		}
+// lockCarCity город объявления до изменения, строка блокируется до конца транзакции
+func lockCarCity(ctx context.Context, tx *sqlx.Tx, id int) (string, error) {
+	var city string
+	if err := tx.GetContext(ctx, &city, "SELECT city FROM cars WHERE id = $1 FOR UPDATE", id); err != nil {
+		return "", translateError(err)
 	}
+	return city, nil
+}
 
-	_, err := c.conn.Exec("INSERT INTO favourites(car_id) VALUES ($1)", favouriteCar.ID)
-	if err != nil {
-		return err
+func carChange(id int, cities ...string) *store.Change {
+	change := &store.Change{Entity: store.ChangeCar, ID: id}
+	for _, city := range cities {
+		if city != "" {
+			change.Cities = append(change.Cities, city)
+		}
 	}
-	return nil
+	return change
 }
 
-func (c CarsRepository) DeleteFromFav(ctx context.Context, filter *models.CarFilter) error {
+func (c CarsRepository) AddToFav(ctx context.Context, userId, carId int) error {
 	favouriteCar := new(models.Car)
-	basicQuery := "SELECT * FROM cars WHERE id = $1"
-
-	if filter.CarId != nil {
-		if err := c.conn.Get(favouriteCar, basicQuery, filter.CarId); err != nil {
-			return err
-		}
+	if err := c.conn.Get(favouriteCar, "SELECT * FROM cars WHERE id = $1", carId); err != nil {
+		return translateError(err)
 	}
 
-	_, err := c.conn.Exec("DELETE FROM favourites WHERE car_id = $1", favouriteCar.ID)
+	_, err := c.conn.Exec("INSERT INTO favourites(user_id, car_id) VALUES ($1, $2) ON CONFLICT (user_id, car_id) DO NOTHING",
+		userId, favouriteCar.ID)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 	return nil
 }
 
-func (c CarsRepository) ShowFav(ctx context.Context) ([]*models.Car, error) {
+func (c CarsRepository) DeleteFromFav(ctx context.Context, userId, carId int) error {
+	return affectOne(c.conn.Exec("DELETE FROM favourites WHERE user_id = $1 AND car_id = $2", userId, carId))
+}
+
+func (c CarsRepository) ShowFav(ctx context.Context, userId int) ([]*models.Car, error) {
 	favouriteCars := make([]*models.Car, 0)
-	err := c.conn.Select(&favouriteCars, "select cars.id, cars.model, cars.brand_id, cars.city, cars.year, cars.description from cars, favourites where cars.id =  favourites.car_id")
+	err := c.conn.Select(&favouriteCars, `SELECT cars.* FROM cars JOIN favourites ON cars.id = favourites.car_id
+		WHERE favourites.user_id = $1 ORDER BY favourites.created_at DESC`, userId)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	return favouriteCars, nil
 }
+
+// orderBy строит ORDER BY по ключам сортировки, id в конце делает порядок однозначным
+func orderBy(keys []models.SortKey) string {
+	columns := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		if !models.CarSortFields[key.Field] {
+			continue
+		}
+		column := key.Field
+		if key.Desc {
+			column += " DESC"
+		}
+		columns = append(columns, column)
+	}
+	columns = append(columns, "id")
+	return " ORDER BY " + strings.Join(columns, ", ")
+}
+
+// keysetCondition отбирает записи строго после курсора в порядке orderBy:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ... OR (k1 = v1 AND ... AND id > last_id)
+func keysetCondition(q *queryBuilder, keys []models.SortKey, after *models.Car) string {
+	or := make([]string, 0, len(keys)+1)
+	equal := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !models.CarSortFields[key.Field] {
+			continue
+		}
+		op := ">"
+		if key.Desc {
+			op = "<"
+		}
+		p := q.arg(after.SortValue(key.Field))
+		and := append(equal[:len(equal):len(equal)], fmt.Sprintf("%s %s %s", key.Field, op, p))
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+		equal = append(equal, fmt.Sprintf("%s = %s", key.Field, p))
+	}
+	and := append(equal, "id > "+q.arg(after.ID))
+	or = append(or, "("+strings.Join(and, " AND ")+")")
+	return "(" + strings.Join(or, " OR ") + ")"
+}
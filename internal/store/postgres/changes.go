@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx"
+	"github.com/jmoiron/sqlx"
+	"log"
+	"net"
+	"project/internal/store"
+	"time"
+)
+
+// ChangesChannel канал NOTIFY, в который репозитории объявлений и брендов пишут о своих изменениях
+const ChangesChannel = "store_changes"
+
+const (
+	// listenPingInterval как часто проверять соединение, если уведомлений нет
+	listenPingInterval = 30 * time.Second
+	listenPingTimeout  = 5 * time.Second
+	listenMinBackoff   = time.Second
+	listenMaxBackoff   = 30 * time.Second
+)
+
+// writeAndNotify выполняет write и pg_notify в одной транзакции, поэтому уведомление доставляется
+// только после коммита и не доставляется при откате
+func writeAndNotify(ctx context.Context, conn *sqlx.DB, write func(tx *sqlx.Tx) (*store.Change, error)) error {
+	return writeAndNotifyAll(ctx, conn, func(tx *sqlx.Tx) ([]*store.Change, error) {
+		change, err := write(tx)
+		if err != nil {
+			return nil, err
+		}
+		return []*store.Change{change}, nil
+	})
+}
+
+// writeAndNotifyAll как writeAndNotify, но для записи, затрагивающей несколько объявлений или брендов
+func writeAndNotifyAll(ctx context.Context, conn *sqlx.DB, write func(tx *sqlx.Tx) ([]*store.Change, error)) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	changes, err := write(tx)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		payload, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", ChangesChannel, string(payload)); err != nil {
+			return translateError(err)
+		}
+	}
+	return translateError(tx.Commit())
+}
+
+// ListenChanges слушает ChangesChannel на отдельном соединении и переподключается при разрыве
+func (db *DB) ListenChanges(ctx context.Context, handle func(store.Change), resync func()) {
+	backoff := listenMinBackoff
+	for {
+		started := time.Now()
+		err := db.listen(ctx, handle, resync)
+		if ctx.Err() != nil {
+			return
+		}
+		// соединение, которое продержалось дольше максимальной паузы, разорвалось не из-за недоступности базы
+		if time.Since(started) > listenMaxBackoff {
+			backoff = listenMinBackoff
+		}
+		log.Printf("[postgres] listen %s: %v, reconnecting in %s", ChangesChannel, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > listenMaxBackoff {
+			backoff = listenMaxBackoff
+		}
+	}
+}
+
+func (db *DB) listen(ctx context.Context, handle func(store.Change), resync func()) error {
+	config, err := pgx.ParseConnectionString(db.url)
+	if err != nil {
+		return err
+	}
+	config.Dial = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: listenPingInterval}).Dial
+	conn, err := pgx.Connect(config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Listen(ChangesChannel); err != nil {
+		return err
+	}
+	// уведомления до LISTEN не пришли, кэш мог устареть
+	resync()
+
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, listenPingInterval)
+		notification, err := conn.WaitForNotification(waitCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pingCtx, cancel := context.WithTimeout(ctx, listenPingTimeout)
+			err = conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		change := store.Change{}
+		if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+			log.Printf("[postgres] bad %s payload %q: %v", ChangesChannel, notification.Payload, err)
+			continue
+		}
+		handle(change)
+	}
+}
@@ -1,22 +1,48 @@
 package postgres
 
 import (
+	"context"
+	"fmt"
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
 	"project/internal/store"
 )
 
 type DB struct {
-	conn   *sqlx.DB
-	brands store.BrandsRepository
-	cars   store.CarsRepository
-	users  store.UsersRepository
+	conn        *sqlx.DB
+	url         string
+	checkSchema bool
+	brands      store.BrandsRepository
+	cars        store.CarsRepository
+	users       store.UsersRepository
+	sessions    store.SessionsRepository
+	revoked     store.RevokedTokensRepository
+	resets      store.PasswordResetsRepository
+	attempts    store.LoginAttemptsRepository
+	audit       store.AuditRepository
+	apiKeys     store.APIKeysRepository
+	twoFactor   store.TwoFactorRepository
+	identities  store.IdentitiesRepository
 }
 
-func NewDB() store.Store {
-	return &DB{}
+type Option func(db *DB)
+
+// WithSchemaCheck заставляет Connect проверять, что все встроенные миграции применены
+func WithSchemaCheck() Option {
+	return func(db *DB) {
+		db.checkSchema = true
+	}
+}
+
+func NewDB(opts ...Option) *DB {
+	db := &DB{}
+	for _, opt := range opts {
+		opt(db)
+	}
+	return db
 }
 
+// Connect открывает соединение и сразу создает репозитории, поэтому аксессоры безопасно вызывать из разных горутин
 func (db *DB) Connect(url string) error {
 	conn, err := sqlx.Connect("pgx", url)
 	if err != nil {
@@ -27,9 +53,39 @@ func (db *DB) Connect(url string) error {
 	}
 
 	db.conn = conn
+	db.brands = newBrandsRepository(conn)
+	db.cars = newCarsRepository(conn)
+	db.users = newUserRepository(conn)
+	db.sessions = newSessionsRepository(conn)
+	db.revoked = newRevokedTokensRepository(conn)
+	db.resets = newPasswordResetsRepository(conn)
+	db.audit = newAuditRepository(conn)
+	db.attempts = newLoginAttemptsRepository(conn)
+	db.apiKeys = newAPIKeysRepository(conn)
+	db.twoFactor = newTwoFactorRepository(conn)
+	db.identities = newIdentitiesRepository(conn)
+	db.url = url
+	if db.checkSchema {
+		return db.verifySchema()
+	}
 	return nil
 }
 
 func (db *DB) Close() error {
 	return db.conn.Close()
 }
+
+func (db *DB) verifySchema() error {
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	current, err := db.SchemaVersion(context.Background())
+	if err != nil {
+		return err
+	}
+	if current != latest {
+		return fmt.Errorf("schema version is %d, expected %d: run the migrate command", current, latest)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx"
+	"project/internal/store"
+)
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+// translateError приводит ошибки pgx и database/sql к ошибкам пакета store
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+
+	var pgErr pgx.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case uniqueViolation:
+			return fmt.Errorf("%w: %s", store.ErrConflict, pgErr.ConstraintName)
+		case foreignKeyViolation:
+			return fmt.Errorf("%w: %s", store.ErrInvalidReference, pgErr.ConstraintName)
+		}
+	}
+	return err
+}
+
+// affectOne возвращает store.ErrNotFound, если запрос не затронул ни одной строки
+func affectOne(result sql.Result, err error) error {
+	if err != nil {
+		return translateError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"project/internal/models"
+	"project/internal/store"
+)
+
+func (db *DB) Identities() store.IdentitiesRepository {
+	return db.identities
+}
+
+type IdentitiesRepository struct {
+	conn *sqlx.DB
+}
+
+func newIdentitiesRepository(conn *sqlx.DB) store.IdentitiesRepository {
+	return &IdentitiesRepository{conn: conn}
+}
+
+func (i IdentitiesRepository) ByExternalID(ctx context.Context, issuer, subject string) (*models.Identity, error) {
+	identity := new(models.Identity)
+	if err := i.conn.GetContext(ctx, identity, "SELECT * FROM user_identities WHERE issuer = $1 AND subject = $2", issuer, subject); err != nil {
+		return nil, translateError(err)
+	}
+	return identity, nil
+}
+
+func (i IdentitiesRepository) Create(ctx context.Context, identity *models.Identity) error {
+	err := i.conn.GetContext(ctx, &identity.CreatedAt, "INSERT INTO user_identities (issuer, subject, user_id, email) VALUES ($1, $2, $3, $4) RETURNING created_at",
+		identity.Issuer, identity.Subject, identity.UserID, identity.Email)
+	return translateError(err)
+}
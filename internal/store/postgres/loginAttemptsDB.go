@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"project/internal/models"
+	"project/internal/store"
+)
+
+func (db *DB) LoginAttempts() store.LoginAttemptsRepository {
+	return db.attempts
+}
+
+type LoginAttemptsRepository struct {
+	conn *sqlx.DB
+}
+
+func newLoginAttemptsRepository(conn *sqlx.DB) store.LoginAttemptsRepository {
+	return &LoginAttemptsRepository{conn: conn}
+}
+
+func (l LoginAttemptsRepository) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	attempt := new(models.LoginAttempt)
+	err := l.conn.GetContext(ctx, attempt, "SELECT * FROM login_attempts WHERE key = $1", key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.LoginAttempt{Key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+// Update сначала вставляет пустую строку, чтобы параллельные попытки по новому ключу
+// блокировали одну и ту же строку
+func (l LoginAttemptsRepository) Update(ctx context.Context, key string, fn func(attempt *models.LoginAttempt)) error {
+	tx, err := l.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO login_attempts (key, blocked_until) VALUES ($1, 'epoch') ON CONFLICT (key) DO NOTHING", key); err != nil {
+		return err
+	}
+	attempt := new(models.LoginAttempt)
+	if err := tx.GetContext(ctx, attempt, "SELECT * FROM login_attempts WHERE key = $1 FOR UPDATE", key); err != nil {
+		return translateError(err)
+	}
+
+	fn(attempt)
+	if attempt.Empty() {
+		_, err = tx.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE login_attempts SET failures = $1, last_failure_at = $2, blocked_until = $3, locked_until = $4 WHERE key = $5",
+			attempt.Failures, attempt.LastFailureAt, attempt.BlockedUntil, attempt.LockedUntil, key)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
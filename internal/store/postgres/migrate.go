@@ -0,0 +1,243 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationsLockKey ключ pg_advisory_lock, под которым применяются и откатываются миграции.
+// Число произвольное, важно только чтобы оно было одинаковым у всех реплик
+const migrationsLockKey = 73_210_001
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations читает встроенные файлы вида 0001_name.up.sql / 0001_name.down.sql
+func loadMigrations() ([]*Migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base := path.Base(file)
+		name := strings.TrimSuffix(base, ".sql")
+		direction := path.Ext(name)
+		name = strings.TrimSuffix(name, direction)
+
+		parts := strings.SplitN(name, "_", 2)
+		if len(parts) != 2 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("invalid migration file name %q", base)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", base, err)
+		}
+
+		body, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == ".up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// LatestSchemaVersion возвращает версию последней встроенной миграции
+func LatestSchemaVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// SchemaVersion возвращает последнюю примененную версию схемы, 0 если миграций не было
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	if _, err := db.conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return 0, err
+	}
+	var version int
+	if err := db.conn.GetContext(ctx, &version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// MigrateUp применяет все непримененные миграции, каждую в своей транзакции
+func (db *DB) MigrateUp(ctx context.Context) ([]*Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := db.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]*Migration, 0)
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		err := db.inTx(ctx, m.Up, "INSERT INTO schema_migrations(version, name) VALUES ($1, $2)", m.Version, m.Name)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// MigrateDown откатывает последнюю примененную миграцию
+func (db *DB) MigrateDown(ctx context.Context) (*Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := db.lockMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current == 0 {
+		return nil, errors.New("no migrations to roll back")
+	}
+
+	for _, m := range migrations {
+		if m.Version != current {
+			continue
+		}
+		err := db.inTx(ctx, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+		if err != nil {
+			return nil, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("applied migration %d is unknown to this binary", current)
+}
+
+func (db *DB) MigrationStatus(ctx context.Context) ([]*MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.SchemaVersion(ctx); err != nil {
+		return nil, err
+	}
+
+	rows := make([]struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}, 0)
+	if err := db.conn.SelectContext(ctx, &rows, "SELECT version, applied_at FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	statuses := make([]*MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := &MigrationStatus{Version: m.Version, Name: m.Name}
+		if t, ok := appliedAt[m.Version]; ok {
+			status.AppliedAt = &t
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// lockMigrations берет advisory lock на отдельном соединении: реплики, запущенные одновременно,
+// применяют миграции по очереди, и вторая уже видит версию, записанную первой
+func (db *DB) lockMigrations(ctx context.Context) (func(), error) {
+	conn, err := db.conn.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLockKey)
+		conn.Close()
+	}, nil
+}
+
+// inTx выполняет скрипт миграции и запись в schema_migrations в одной транзакции
+func (db *DB) inTx(ctx context.Context, script, record string, args ...interface{}) error {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,4 @@
+DROP TABLE favourites;
+DROP TABLE cars;
+DROP TABLE users;
+DROP TABLE brands;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS brands
+(
+    id   SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users
+(
+    id           SERIAL PRIMARY KEY,
+    name         VARCHAR(255) NOT NULL DEFAULT '',
+    surname      VARCHAR(255) NOT NULL DEFAULT '',
+    email        VARCHAR(255) NOT NULL UNIQUE,
+    password     VARCHAR(255) NOT NULL,
+    phone_number VARCHAR(32)  NOT NULL DEFAULT '',
+    birth_date   VARCHAR(32)  NOT NULL DEFAULT '',
+    role         VARCHAR(32)
+);
+
+CREATE TABLE IF NOT EXISTS cars
+(
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    model       VARCHAR(255) NOT NULL,
+    brand_id    INTEGER      NOT NULL REFERENCES brands (id),
+    city        VARCHAR(255) NOT NULL,
+    year        INTEGER      NOT NULL,
+    price       INTEGER      NOT NULL,
+    description TEXT         NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS favourites
+(
+    car_id INTEGER NOT NULL
+);
@@ -0,0 +1,6 @@
+DROP TABLE favourites;
+
+CREATE TABLE favourites
+(
+    car_id INTEGER NOT NULL
+);
@@ -0,0 +1,10 @@
+-- старые избранные не привязаны к пользователю, перенести их некуда
+DROP TABLE favourites;
+
+CREATE TABLE favourites
+(
+    user_id    INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    car_id     INTEGER     NOT NULL REFERENCES cars (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, car_id)
+);
@@ -0,0 +1,4 @@
+DROP TABLE revoked_tokens;
+
+ALTER TABLE sessions
+    DROP COLUMN access_token_id;
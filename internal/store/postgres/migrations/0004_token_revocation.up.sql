@@ -0,0 +1,8 @@
+ALTER TABLE sessions
+    ADD COLUMN access_token_id VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE TABLE revoked_tokens
+(
+    jti        VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,6 @@
+ALTER TABLE users
+    ADD COLUMN verified_at          TIMESTAMPTZ,
+    ADD COLUMN verification_sent_at TIMESTAMPTZ;
+
+-- аккаунты, созданные до появления проверки, считаются подтвержденными
+UPDATE users SET verified_at = now();
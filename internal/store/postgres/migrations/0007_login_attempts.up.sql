@@ -0,0 +1,20 @@
+CREATE TABLE login_attempts
+(
+    key             VARCHAR(320) PRIMARY KEY,
+    failures        INTEGER     NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    blocked_until   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until    TIMESTAMPTZ
+);
+
+CREATE TABLE audit_log
+(
+    id         SERIAL PRIMARY KEY,
+    event      VARCHAR(64) NOT NULL,
+    subject    TEXT        NOT NULL,
+    ip         VARCHAR(64) NOT NULL DEFAULT '',
+    actor_id   INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP CONSTRAINT users_role_check,
+    ALTER COLUMN role DROP NOT NULL,
+    ALTER COLUMN role DROP DEFAULT;
@@ -0,0 +1,6 @@
+UPDATE users SET role = 'client' WHERE role IS NULL OR role NOT IN ('admin', 'moderator', 'dealer', 'client');
+
+ALTER TABLE users
+    ALTER COLUMN role SET DEFAULT 'client',
+    ALTER COLUMN role SET NOT NULL,
+    ADD CONSTRAINT users_role_check CHECK (role IN ('admin', 'moderator', 'dealer', 'client'));
@@ -0,0 +1,17 @@
+CREATE TABLE two_factor
+(
+    user_id    INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret     VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    enabled_at TIMESTAMPTZ,
+    last_step  BIGINT      NOT NULL DEFAULT 0
+);
+
+CREATE TABLE recovery_codes
+(
+    code_hash CHAR(64) PRIMARY KEY,
+    user_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    used_at   TIMESTAMPTZ
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) PasswordResets() store.PasswordResetsRepository {
+	return db.resets
+}
+
+type PasswordResetsRepository struct {
+	conn *sqlx.DB
+}
+
+func newPasswordResetsRepository(conn *sqlx.DB) store.PasswordResetsRepository {
+	return &PasswordResetsRepository{conn: conn}
+}
+
+// Create блокирует строку пользователя, чтобы одновременные запросы не выдали по токену каждый
+func (p PasswordResetsRepository) Create(ctx context.Context, reset *models.PasswordReset, since time.Time) error {
+	tx, err := p.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	if err := tx.GetContext(ctx, &userID, "SELECT id FROM users WHERE id = $1 FOR NO KEY UPDATE", reset.UserID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: user %d", store.ErrInvalidReference, reset.UserID)
+		}
+		return err
+	}
+	var recent bool
+	err = tx.GetContext(ctx, &recent, "SELECT EXISTS (SELECT 1 FROM password_resets WHERE user_id = $1 AND created_at > $2)", reset.UserID, since)
+	if err != nil {
+		return err
+	}
+	if recent {
+		return fmt.Errorf("%w: password reset issued recently", store.ErrConflict)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL", reset.UserID); err != nil {
+		return err
+	}
+	err = tx.GetContext(ctx, &reset.CreatedAt, "INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3) RETURNING created_at",
+		reset.TokenHash, reset.UserID, reset.ExpiresAt)
+	if err != nil {
+		return translateError(err)
+	}
+	return tx.Commit()
+}
+
+// Consume проверяет и гасит токен одним запросом, поэтому токен нельзя использовать дважды
+func (p PasswordResetsRepository) Consume(ctx context.Context, tokenHash string, at time.Time) (*models.PasswordReset, error) {
+	reset := new(models.PasswordReset)
+	err := p.conn.GetContext(ctx, reset, "UPDATE password_resets SET used_at = $1 WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1 RETURNING *",
+		at, tokenHash)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return reset, nil
+}
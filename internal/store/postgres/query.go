@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// queryBuilder собирает WHERE условия с нумерованными плейсхолдерами $1, $2, ...
+type queryBuilder struct {
+	conditions []string
+	values     []interface{}
+}
+
+func (q *queryBuilder) arg(value interface{}) string {
+	q.values = append(q.values, value)
+	return fmt.Sprintf("$%d", len(q.values))
+}
+
+// args раскрывает слайс в список плейсхолдеров для IN (...)
+func (q *queryBuilder) args(slice interface{}) string {
+	v := reflect.ValueOf(slice)
+	placeholders := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		placeholders = append(placeholders, q.arg(v.Index(i).Interface()))
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+func (q *queryBuilder) where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+func (q *queryBuilder) whereClause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// limit запрашивает на одну запись больше, чтобы понять, есть ли следующая страница
+func (q *queryBuilder) limit(limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	return " LIMIT " + q.arg(limit+1)
+}
@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) RevokedTokens() store.RevokedTokensRepository {
+	return db.revoked
+}
+
+type RevokedTokensRepository struct {
+	conn *sqlx.DB
+}
+
+func newRevokedTokensRepository(conn *sqlx.DB) store.RevokedTokensRepository {
+	return &RevokedTokensRepository{conn: conn}
+}
+
+// Add заодно удаляет записи об уже истекших токенах, чтобы таблица не росла
+func (r RevokedTokensRepository) Add(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := r.conn.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < $1", time.Now()); err != nil {
+		return err
+	}
+	_, err := r.conn.ExecContext(ctx, "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", jti, expiresAt)
+	return translateError(err)
+}
+
+func (r RevokedTokensRepository) Contains(ctx context.Context, jti string) (bool, error) {
+	var exists bool
+	if err := r.conn.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
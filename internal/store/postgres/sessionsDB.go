@@ -0,0 +1,130 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) Sessions() store.SessionsRepository {
+	return db.sessions
+}
+
+type SessionsRepository struct {
+	conn *sqlx.DB
+}
+
+func newSessionsRepository(conn *sqlx.DB) store.SessionsRepository {
+	return &SessionsRepository{conn: conn}
+}
+
+func (s SessionsRepository) Create(ctx context.Context, session *models.Session, token *models.RefreshToken) error {
+	tx, err := s.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.GetContext(ctx, session, `INSERT INTO sessions (user_id, user_agent, ip, expires_at, access_token_id) VALUES ($1, $2, $3, $4, $5) RETURNING *`,
+		session.UserID, session.UserAgent, session.IP, session.ExpiresAt, session.AccessTokenID)
+	if err != nil {
+		return translateError(err)
+	}
+	token.SessionID = session.ID
+	if err := insertRefreshToken(ctx, tx, token); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rotate блокирует строку токена, поэтому два параллельных запроса с одним токеном
+// не могут оба получить новую пару
+func (s SessionsRepository) Rotate(ctx context.Context, tokenHash string, next *models.RefreshToken, accessTokenID string) (*models.Session, error) {
+	tx, err := s.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	token := new(models.RefreshToken)
+	if err := tx.GetContext(ctx, token, "SELECT * FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE", tokenHash); err != nil {
+		return nil, translateError(err)
+	}
+	if token.UsedAt != nil {
+		session := new(models.Session)
+		err := tx.GetContext(ctx, session, "UPDATE sessions SET revoked_at = COALESCE(revoked_at, $1) WHERE id = $2 RETURNING *", now, token.SessionID)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return session, store.ErrTokenReused
+	}
+	if !now.Before(token.ExpiresAt) {
+		return nil, store.ErrNotFound
+	}
+
+	session := new(models.Session)
+	if err := tx.GetContext(ctx, session, "SELECT * FROM sessions WHERE id = $1 FOR UPDATE", token.SessionID); err != nil {
+		return nil, translateError(err)
+	}
+	if !session.Active(now) {
+		return nil, store.ErrNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = $1 WHERE token_hash = $2", now, tokenHash); err != nil {
+		return nil, err
+	}
+	next.SessionID = session.ID
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		return nil, err
+	}
+	session.LastUsedAt, session.ExpiresAt, session.AccessTokenID = now, next.ExpiresAt, accessTokenID
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET last_used_at = $1, expires_at = $2, access_token_id = $3 WHERE id = $4",
+		now, next.ExpiresAt, accessTokenID, session.ID); err != nil {
+		return nil, err
+	}
+	return session, tx.Commit()
+}
+
+func (s SessionsRepository) ByID(ctx context.Context, id int) (*models.Session, error) {
+	session := new(models.Session)
+	if err := s.conn.GetContext(ctx, session, "SELECT * FROM sessions WHERE id = $1", id); err != nil {
+		return nil, translateError(err)
+	}
+	return session, nil
+}
+
+func (s SessionsRepository) ActiveOfUser(ctx context.Context, userID int) ([]*models.Session, error) {
+	sessions := make([]*models.Session, 0)
+	err := s.conn.SelectContext(ctx, &sessions, "SELECT * FROM sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2 ORDER BY last_used_at DESC",
+		userID, time.Now())
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return sessions, nil
+}
+
+func (s SessionsRepository) Revoke(ctx context.Context, id int, at time.Time) error {
+	return affectOne(s.conn.ExecContext(ctx, "UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", at, id))
+}
+
+func (s SessionsRepository) RevokeAllOfUser(ctx context.Context, userID int, at time.Time) ([]*models.Session, error) {
+	sessions := make([]*models.Session, 0)
+	err := s.conn.SelectContext(ctx, &sessions, "UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL AND expires_at > $1 RETURNING *",
+		at, userID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return sessions, nil
+}
+
+func insertRefreshToken(ctx context.Context, tx *sqlx.Tx, token *models.RefreshToken) error {
+	err := tx.GetContext(ctx, &token.CreatedAt, "INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3) RETURNING created_at",
+		token.Hash, token.SessionID, token.ExpiresAt)
+	return translateError(err)
+}
@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"project/internal/models"
+	"project/internal/store"
+	"time"
+)
+
+func (db *DB) TwoFactor() store.TwoFactorRepository {
+	return db.twoFactor
+}
+
+type TwoFactorRepository struct {
+	conn *sqlx.DB
+}
+
+func newTwoFactorRepository(conn *sqlx.DB) store.TwoFactorRepository {
+	return &TwoFactorRepository{conn: conn}
+}
+
+func (t TwoFactorRepository) ByUser(ctx context.Context, userID int) (*models.TwoFactor, error) {
+	twoFactor := new(models.TwoFactor)
+	if err := t.conn.GetContext(ctx, twoFactor, "SELECT * FROM two_factor WHERE user_id = $1", userID); err != nil {
+		return nil, translateError(err)
+	}
+	return twoFactor, nil
+}
+
+func (t TwoFactorRepository) Begin(ctx context.Context, twoFactor *models.TwoFactor) error {
+	err := affectOne(t.conn.ExecContext(ctx, `INSERT INTO two_factor (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = now(), last_step = 0
+		WHERE two_factor.enabled_at IS NULL`, twoFactor.UserID, twoFactor.Secret))
+	if errors.Is(err, store.ErrNotFound) {
+		return store.ErrConflict
+	}
+	return err
+}
+
+func (t TwoFactorRepository) Enable(ctx context.Context, userID int, step int64, at time.Time, codeHashes []string) error {
+	tx, err := t.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = affectOne(tx.ExecContext(ctx, "UPDATE two_factor SET enabled_at = $1, last_step = $2 WHERE user_id = $3 AND enabled_at IS NULL AND last_step < $2",
+		at, step, userID))
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t TwoFactorRepository) UseStep(ctx context.Context, userID int, step int64) error {
+	return affectOne(t.conn.ExecContext(ctx, "UPDATE two_factor SET last_step = $1 WHERE user_id = $2 AND enabled_at IS NOT NULL AND last_step < $1",
+		step, userID))
+}
+
+func (t TwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	tx, err := t.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (code_hash, user_id) VALUES ($1, $2)", hash, userID); err != nil {
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+func (t TwoFactorRepository) UseRecoveryCode(ctx context.Context, userID int, codeHash string, at time.Time) error {
+	return affectOne(t.conn.ExecContext(ctx, "UPDATE recovery_codes SET used_at = $1 WHERE code_hash = $2 AND user_id = $3 AND used_at IS NULL",
+		at, codeHash, userID))
+}
+
+func (t TwoFactorRepository) Disable(ctx context.Context, userID int) error {
+	tx, err := t.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := affectOne(tx.ExecContext(ctx, "DELETE FROM two_factor WHERE user_id = $1", userID)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
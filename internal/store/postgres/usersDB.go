@@ -5,12 +5,10 @@ import (
 	"github.com/jmoiron/sqlx"
 	"project/internal/models"
 	"project/internal/store"
+	"time"
 )
 
 func (db *DB) Users() store.UsersRepository {
-	if db.users == nil {
-		db.users = newUserRepository(db.conn)
-	}
 	return db.users
 }
 
@@ -29,32 +27,48 @@ func (u UsersRepository) Create(ctx context.Context, user *models.User) error {
 	if err := user.BeforeCreating(); err != nil {
 		return err
 	}
-	_, err := u.conn.Exec("INSERT INTO users(name, surname, email, password, phone_number, birth_date, role) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+	err := u.conn.Get(&user.ID, "INSERT INTO users(name, surname, email, password, phone_number, birth_date, role) VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, 'client')) RETURNING id",
 		user.Name, user.Surname, user.Email, user.EncryptedPassword, user.PhoneNumber, user.BirthDate, user.Role)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 	return nil
 }
 
-func (u UsersRepository) All(ctx context.Context) ([]*models.User, error) {
+func (u UsersRepository) All(ctx context.Context, filter *models.UserFilter) (*models.Page[*models.User], error) {
 	users := make([]*models.User, 0)
-	basicQuery := "SELECT * FROM users"
+	q := new(queryBuilder)
 
-	if err := u.conn.Select(&users, basicQuery); err != nil {
-		return nil, err
+	if filter.After != nil {
+		q.where("id > " + q.arg(filter.After.ID))
+	}
+
+	query := "SELECT * FROM users" + q.whereClause() + " ORDER BY id" + q.limit(filter.Limit)
+	if err := u.conn.Select(&users, query, q.values...); err != nil {
+		return nil, translateError(err)
+	}
+	return models.NewPage(users, filter.Limit, func(user *models.User) *models.Cursor {
+		return &models.Cursor{ID: user.ID}
+	}), nil
+}
+
+func (u UsersRepository) ByID(ctx context.Context, id int) (*models.User, error) {
+	user := new(models.User)
+	if err := u.conn.Get(user, "SELECT * FROM users WHERE id=$1", id); err != nil {
+		return nil, translateError(err)
 	}
-	return users, nil
+	return user, nil
 }
 
 func (u UsersRepository) ByEmail(ctx context.Context, email string) (*models.User, error) {
 	user := new(models.User)
 	if err := u.conn.Get(user, "SELECT * FROM users WHERE email=$1", email); err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	return user, nil
 }
 
+// Update меняет данные пользователя с user.ID, пароль меняется только если он передан
 func (u UsersRepository) Update(ctx context.Context, user *models.User) error {
 	if err := user.Validate(); err != nil {
 		return err
@@ -62,17 +76,51 @@ func (u UsersRepository) Update(ctx context.Context, user *models.User) error {
 	if err := user.BeforeCreating(); err != nil {
 		return err
 	}
-	_, err := u.conn.Exec("UPDATE users SET name = $1, surname = $2, password = $3, phone_number = $4, birth_date = $5, role = $6",
-		user.Name, user.Surname, user.Password, user.PhoneNumber, user.BirthDate, user.Role)
-	if err != nil {
-		return err
-	}
-	return nil
+	return affectOne(u.conn.Exec(`UPDATE users SET name = $1, surname = $2, password = COALESCE(NULLIF($3, ''), password),
+		phone_number = $4, birth_date = $5, role = COALESCE($6, role) WHERE id = $7`,
+		user.Name, user.Surname, user.EncryptedPassword, user.PhoneNumber, user.BirthDate, user.Role, user.ID))
 }
 
-func (u UsersRepository) Delete(ctx context.Context, id int) error {
-	if _, err := u.conn.Exec("DELETE FROM users WHERE id = $1", id); err != nil {
-		return err
+func (u UsersRepository) UpdatePassword(ctx context.Context, user *models.User) error {
+	return affectOne(u.conn.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", user.EncryptedPassword, user.ID))
+}
+
+func (u UsersRepository) UpdateRole(ctx context.Context, id int, role models.Role) error {
+	return affectOne(u.conn.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, id))
+}
+
+func (u UsersRepository) MarkVerified(ctx context.Context, id int, at time.Time) error {
+	return affectOne(u.conn.ExecContext(ctx, "UPDATE users SET verified_at = COALESCE(verified_at, $1) WHERE id = $2", at, id))
+}
+
+func (u UsersRepository) MarkVerificationSent(ctx context.Context, id int, at, since time.Time) error {
+	return affectOne(u.conn.ExecContext(ctx, `UPDATE users SET verification_sent_at = $1
+		WHERE id = $2 AND verified_at IS NULL AND (verification_sent_at IS NULL OR verification_sent_at < $3)`, at, id, since))
+}
+
+// Delete удаляет объявления явно, а не каскадом, чтобы сообщить о каждом из них
+func (u UsersRepository) Delete(ctx context.Context, id int) ([]*models.Car, error) {
+	cars := make([]*models.Car, 0)
+	err := writeAndNotifyAll(ctx, u.conn, func(tx *sqlx.Tx) ([]*store.Change, error) {
+		// блокировка пользователя не дает добавить ему объявление, пока он удаляется
+		var locked int
+		if err := tx.GetContext(ctx, &locked, "SELECT id FROM users WHERE id = $1 FOR UPDATE", id); err != nil {
+			return nil, translateError(err)
+		}
+		if err := tx.SelectContext(ctx, &cars, "DELETE FROM cars WHERE user_id = $1 RETURNING *", id); err != nil {
+			return nil, translateError(err)
+		}
+		if err := affectOne(tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)); err != nil {
+			return nil, err
+		}
+		changes := make([]*store.Change, 0, len(cars))
+		for _, car := range cars {
+			changes = append(changes, carChange(car.ID, car.City))
+		}
+		return changes, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return cars, nil
 }
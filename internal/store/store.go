@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"project/internal/models"
+	"time"
 )
 
 type Store interface {
@@ -11,11 +12,20 @@ type Store interface {
 	Brands() BrandsRepository
 	Cars() CarsRepository
 	Users() UsersRepository
+	Sessions() SessionsRepository
+	RevokedTokens() RevokedTokensRepository
+	PasswordResets() PasswordResetsRepository
+	LoginAttempts() LoginAttemptsRepository
+	Audit() AuditRepository
+	APIKeys() APIKeysRepository
+	TwoFactor() TwoFactorRepository
+	Identities() IdentitiesRepository
 }
 
 type BrandsRepository interface {
 	Create(ctx context.Context, brand *models.Brand) error
-	All(ctx context.Context, filter *models.BrandFilter) ([]*models.Brand, error)
+	Upsert(ctx context.Context, brand *models.Brand) error
+	All(ctx context.Context, filter *models.BrandFilter) (*models.Page[*models.Brand], error)
 	ByID(ctx context.Context, id int) (*models.Brand, error)
 	Update(ctx context.Context, brand *models.Brand) error
 	Delete(ctx context.Context, id int) error
@@ -23,22 +33,123 @@ type BrandsRepository interface {
 
 type CarsRepository interface {
 	Create(ctx context.Context, car *models.Car) error
-	All(ctx context.Context, filter *models.CarFilter) ([]*models.Car, error)
+	Upsert(ctx context.Context, car *models.Car) error
+	All(ctx context.Context, filter *models.CarFilter) (*models.Page[*models.Car], error)
 	AllOfUser(ctx context.Context, userId int) ([]*models.Car, error)
 	ByID(ctx context.Context, id int) (*models.Car, error)
 	Update(ctx context.Context, car *models.Car) error
 	Delete(ctx context.Context, id int) error
-	Sort(ctx context.Context, sortType string) ([]*models.Car, error)
-	FilterByCity(ctx context.Context, filter string) ([]*models.Car, error)
-	AddToFav(ctx context.Context, filter *models.CarFilter) error
-	ShowFav(ctx context.Context) ([]*models.Car, error)
-	DeleteFromFav(ctx context.Context, filter *models.CarFilter) error
+	AddToFav(ctx context.Context, userId, carId int) error
+	ShowFav(ctx context.Context, userId int) ([]*models.Car, error)
+	DeleteFromFav(ctx context.Context, userId, carId int) error
 }
 
 type UsersRepository interface {
 	Create(ctx context.Context, user *models.User) error
-	All(ctx context.Context) ([]*models.User, error)
+	All(ctx context.Context, filter *models.UserFilter) (*models.Page[*models.User], error)
+	ByID(ctx context.Context, id int) (*models.User, error)
 	ByEmail(ctx context.Context, email string) (*models.User, error)
 	Update(ctx context.Context, user *models.User) error
-	Delete(ctx context.Context, id int) error
+	// UpdatePassword сохраняет user.EncryptedPassword пользователя с user.ID
+	UpdatePassword(ctx context.Context, user *models.User) error
+	UpdateRole(ctx context.Context, id int, role models.Role) error
+	MarkVerified(ctx context.Context, id int, at time.Time) error
+	// MarkVerificationSent запоминает отправку письма с подтверждением. Если пользователь уже подтвержден
+	// или письмо отправлялось после since, возвращает ErrNotFound
+	MarkVerificationSent(ctx context.Context, id int, at, since time.Time) error
+	// Delete удаляет пользователя вместе с его объявлениями и возвращает удаленные объявления
+	Delete(ctx context.Context, id int) ([]*models.Car, error)
+}
+
+type SessionsRepository interface {
+	// Create сохраняет сессию и ее первый refresh токен
+	Create(ctx context.Context, session *models.Session, token *models.RefreshToken) error
+	// Rotate помечает токен использованным, выдает сессии next и запоминает jti нового access токена.
+	// Повторное предъявление уже использованного токена отзывает всю сессию и возвращает ErrTokenReused
+	// вместе с отозванной сессией, чтобы вызывающий отозвал и ее access токен
+	Rotate(ctx context.Context, tokenHash string, next *models.RefreshToken, accessTokenID string) (*models.Session, error)
+	ByID(ctx context.Context, id int) (*models.Session, error)
+	// ActiveOfUser возвращает неотозванные и неистекшие сессии пользователя
+	ActiveOfUser(ctx context.Context, userID int) ([]*models.Session, error)
+	Revoke(ctx context.Context, id int, at time.Time) error
+	// RevokeAllOfUser отзывает все активные сессии пользователя и возвращает их
+	RevokeAllOfUser(ctx context.Context, userID int, at time.Time) ([]*models.Session, error)
+}
+
+// RevokedTokensRepository список отозванных access токенов. Записи хранятся до истечения токена
+type RevokedTokensRepository interface {
+	Add(ctx context.Context, jti string, expiresAt time.Time) error
+	Contains(ctx context.Context, jti string) (bool, error)
+}
+
+type PasswordResetsRepository interface {
+	// Create сохраняет токен, ранее выданные неиспользованные токены пользователя перестают действовать.
+	// Если пользователю уже выдан токен после since, возвращает ErrConflict
+	Create(ctx context.Context, reset *models.PasswordReset, since time.Time) error
+	// Consume помечает токен использованным. Неизвестный, истекший или использованный токен - ErrNotFound
+	Consume(ctx context.Context, tokenHash string, at time.Time) (*models.PasswordReset, error)
+}
+
+type LoginAttemptsRepository interface {
+	// Get читает счетчик ключа без блокировки, отсутствующий возвращается пустым
+	Get(ctx context.Context, key string) (*models.LoginAttempt, error)
+	// Update читает счетчик ключа под блокировкой, применяет fn и сохраняет результат.
+	// Отсутствующий счетчик передается в fn пустым, пустой после fn удаляется
+	Update(ctx context.Context, key string, fn func(attempt *models.LoginAttempt)) error
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *models.AuditEvent) error
+}
+
+type APIKeysRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	ByID(ctx context.Context, id int) (*models.APIKey, error)
+	// ByHash возвращает только неотозванный ключ
+	ByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	AllOfUser(ctx context.Context, userID int) ([]*models.APIKey, error)
+	Revoke(ctx context.Context, id int, at time.Time) error
+	Touch(ctx context.Context, id int, at time.Time) error
+}
+
+type TwoFactorRepository interface {
+	// ByUser ErrNotFound, если пользователь не начинал подключение 2FA
+	ByUser(ctx context.Context, userID int) (*models.TwoFactor, error)
+	// Begin сохраняет новый неподтвержденный секрет. Если 2FA уже включена - ErrConflict
+	Begin(ctx context.Context, twoFactor *models.TwoFactor) error
+	// Enable подтверждает секрет кодом шага step и заменяет коды восстановления
+	Enable(ctx context.Context, userID int, step int64, at time.Time, codeHashes []string) error
+	// UseStep запоминает принятый шаг. Шаг не новее последнего принятого - ErrNotFound
+	UseStep(ctx context.Context, userID int, step int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	// UseRecoveryCode гасит код восстановления. Неизвестный или использованный код - ErrNotFound
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string, at time.Time) error
+	Disable(ctx context.Context, userID int) error
+}
+
+type IdentitiesRepository interface {
+	ByExternalID(ctx context.Context, issuer, subject string) (*models.Identity, error)
+	// Create привязывает внешний аккаунт. Если он уже привязан - ErrConflict
+	Create(ctx context.Context, identity *models.Identity) error
+}
+
+// Сущности, об изменении которых сообщает ChangeListener
+const (
+	ChangeCar   = "car"
+	ChangeBrand = "brand"
+)
+
+// Change закоммиченное изменение объявления или бренда
+type Change struct {
+	Entity string `json:"entity"`
+	ID     int    `json:"id"`
+	// Cities города объявления до и после изменения
+	Cities []string `json:"cities,omitempty"`
+}
+
+// ChangeListener получает изменения, закоммиченные любым процессом с тем же хранилищем
+type ChangeListener interface {
+	// ListenChanges передает изменения в handle до отмены ctx. Изменения за время разрыва
+	// соединения теряются, поэтому после каждого подключения вызывается resync
+	ListenChanges(ctx context.Context, handle func(Change), resync func())
 }